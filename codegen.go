@@ -3,15 +3,19 @@ package codegen
 import (
 	"bufio"
 	"bytes"
+	"errors"
 	"fmt"
+	"go/build/constraint"
+	"go/scanner"
+	"go/token"
 	"io"
 	"io/ioutil"
 	"math"
 	"os"
-	"path/filepath"
+	"regexp"
 	"strings"
 
-	"golang.org/x/tools/imports"
+	"github.com/lestrrat-go/codegen/internal/diff"
 )
 
 // R is a short hand for fmt.Fprintf(...)
@@ -29,9 +33,29 @@ func LL(dst io.Writer, s string, args ...interface{}) {
 	R(dst, "\n\n"+s, args...)
 }
 
+const defaultIndentString = "\t"
+
 type Output struct {
-	src io.Reader
-	dst io.Writer
+	src  io.Reader
+	dst  io.Writer
+	read []byte // code read from src so far, kept so that it can be rendered again
+
+	indent       int
+	indentString string
+	bol          bool // true if the next byte starts a new line
+
+	err      error // first error encountered while writing
+	written  int64 // number of bytes written so far
+	pkgStart int64 // offset of the package clause, or -1
+	pkgEnd   int64 // offset right after the package clause, or -1
+	imports  *importSet
+
+	buildConstraint constraint.Expr
+	directives      []string
+
+	sections     map[string]*section
+	sectionOrder []*section
+	reservations int // number of sections reserved so far
 }
 
 func NewOutput(dst io.Writer) *Output {
@@ -40,19 +64,160 @@ func NewOutput(dst io.Writer) *Output {
 	if v, ok := dst.(io.Reader); ok {
 		o.src = v
 	}
+	o.indentString = defaultIndentString
+	o.bol = true
+	o.pkgStart = -1
+	o.pkgEnd = -1
+	o.imports = newImportSet()
 	return o
 }
 
 func (o *Output) R(s string, args ...interface{}) {
-	R(o.dst, s, args...)
+	o.write(fmt.Sprintf(s, args...))
 }
 
 func (o *Output) L(s string, args ...interface{}) {
-	L(o.dst, s, args...)
+	o.R("\n"+s, args...)
 }
 
 func (o *Output) LL(s string, args ...interface{}) {
-	LL(o.dst, s, args...)
+	o.R("\n\n"+s, args...)
+}
+
+// write writes s to the destination, prefixing each non-empty line
+// with the current indentation.
+//
+// Once writing to the destination fails, the error is recorded and
+// all subsequent writes are ignored
+func (o *Output) write(s string) {
+	if o.err != nil || len(s) == 0 {
+		return
+	}
+
+	if o.indent == 0 {
+		o.bol = s[len(s)-1] == '\n'
+		o.writeString(s)
+		return
+	}
+
+	prefix := strings.Repeat(o.indentString, o.indent)
+	var buf strings.Builder
+	for len(s) > 0 {
+		var line string
+		if i := strings.IndexByte(s, '\n'); i < 0 {
+			line, s = s, ""
+		} else {
+			line, s = s[:i+1], s[i+1:]
+		}
+
+		if o.bol && line != "\n" {
+			buf.WriteString(prefix)
+		}
+		buf.WriteString(line)
+		o.bol = line[len(line)-1] == '\n'
+	}
+	o.writeString(buf.String())
+}
+
+// lengther is implemented by destinations such as *bytes.Buffer, which
+// report the number of bytes that can still be read from them
+type lengther interface {
+	Len() int
+}
+
+// position returns the offset in the code read back from the destination
+// at which the next byte will be written.
+//
+// If the destination reports its length, the offset also accounts for
+// bytes that were not written through the Output, such as content that
+// was already in the buffer, or code written using the package level R,
+// L, and LL. Otherwise only the bytes written through the Output are
+// counted
+func (o *Output) position() int64 {
+	if l, ok := o.dst.(lengther); ok && o.src != nil {
+		return int64(len(o.read) + l.Len())
+	}
+	return o.written
+}
+
+// exactPositions reports whether the offsets returned by position are
+// known to match the code read back from the destination, `buf`
+func (o *Output) exactPositions(buf []byte) bool {
+	if _, ok := o.dst.(lengther); ok {
+		return true
+	}
+	return int64(len(buf)) == o.written
+}
+
+func (o *Output) writeString(s string) {
+	n, err := io.WriteString(o.dst, s)
+	o.written += int64(n)
+	if err != nil {
+		o.err = fmt.Errorf(`failed to write to destination: %w`, err)
+	}
+}
+
+// Err returns the first error that occurred while writing to the
+// destination. Once an error has occurred, further writes are
+// discarded, and Write and WriteFile return the same error
+func (o *Output) Err() error {
+	return o.err
+}
+
+// outputWriter allows functions that take an io.Writer to
+// write through the Output, so that indentation is applied
+type outputWriter struct {
+	o *Output
+}
+
+func (w outputWriter) Write(p []byte) (int, error) {
+	w.o.write(string(p))
+	if err := w.o.err; err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// SetIndentString changes the string used for a single level of
+// indentation. The default is a single tab character
+func (o *Output) SetIndentString(s string) {
+	o.indentString = s
+}
+
+// Indent increases the indentation level by one. Every line
+// written afterwards is prefixed with the indentation string,
+// except for empty lines.
+//
+// Note that multi-line raw string literals are indented as well,
+// so emit them after calling Dedent if their contents matter
+func (o *Output) Indent() {
+	o.indent++
+}
+
+// Dedent decreases the indentation level by one
+func (o *Output) Dedent() {
+	if o.indent > 0 {
+		o.indent--
+	}
+}
+
+// Block writes `header {` on a new line, calls fn with the
+// indentation level increased by one, and then closes the
+// block with `}` on a new line.
+//
+//	o.Block("func main()", func() {
+//	  o.L("fmt.Println(\"Hello, World!\")")
+//	})
+func (o *Output) Block(header string, fn func()) {
+	if header == "" {
+		o.L("{")
+	} else {
+		o.L("%s {", header)
+	}
+	o.Indent()
+	fn()
+	o.Dedent()
+	o.L("}")
 }
 
 // Comment outputs multi-line comments, prefixed with a '//` marker
@@ -83,8 +248,55 @@ func (o *Output) Comment(s string) {
 }
 
 func (o *Output) WritePackage(s string, args ...interface{}) {
-	L(o.dst, "package ")
-	R(o.dst, s, args...)
+	o.L("")
+	o.pkgStart = o.position()
+	o.R("package ")
+	o.R(s, args...)
+	o.pkgEnd = o.position()
+}
+
+// Qual returns the qualified identifier `name` in package `pkgPath`
+// (e.g. "url.URL" for `o.Qual("net/url", "URL")`), and records the
+// package as a dependency of the generated code.
+//
+// Packages recorded this way are rendered as an import block right
+// after the package clause when Write or WriteFile is called, so
+// there is no need to list them beforehand. If the name derived from
+// the import path clashes with another package, a numeric suffix is
+// appended to it (e.g. "url2").
+//
+// If the package was imported using ImportAs with the alias ".", the
+// bare name is returned. Packages imported with the alias "_" can not
+// be referred to, so the error is recorded and reported from Err,
+// Write, and WriteFile
+func (o *Output) Qual(pkgPath, name string) string {
+	// names derived from the import path never clash
+	imp, _ := o.imports.add(pkgPath, "")
+	switch imp.name {
+	case ".":
+		return name
+	case "_":
+		if o.err == nil {
+			o.err = fmt.Errorf(`can not refer to %q in package %q, which is imported for its side effects only`, name, pkgPath)
+		}
+		return name
+	}
+	return imp.name + "." + name
+}
+
+// ImportAs records the package `pkgPath` as a dependency of the
+// generated code, to be referred to by the given alias. It must be
+// called before any calls to Qual for the same package to take effect.
+//
+// The alias may be "_" to import a package only for its side effects.
+//
+// If the alias is already used by another package, or the package has
+// already been referred to by a different name, the error is recorded
+// and reported from Err, Write, and WriteFile
+func (o *Output) ImportAs(pkgPath, alias string) {
+	if _, err := o.imports.add(pkgPath, alias); err != nil && o.err == nil {
+		o.err = err
+	}
 }
 
 type ImportPkg struct {
@@ -102,16 +314,153 @@ func (o *Output) WriteImports(urls ...string) error {
 	return o.WriteImportPkgs(pkgs...)
 }
 
+// WriteImportPkgs writes an import block containing the given
+// packages. Packages written this way are not repeated in the import
+// block that is automatically generated for packages used via Qual.
+//
+// If a package has already been referred to via Qual or ImportAs, it
+// is imported using the same name. An error is returned if the alias
+// differs from that name, or is already used by another package
 func (o *Output) WriteImportPkgs(pkgs ...ImportPkg) error {
-	return WriteImports(o.dst, pkgs...)
+	resolved := make([]ImportPkg, 0, len(pkgs))
+	for _, pkg := range pkgs {
+		imp, err := o.imports.add(pkg.URL, pkg.Alias)
+		if err != nil {
+			return err
+		}
+		imp.written = true
+
+		// the name may have been adjusted to avoid a clash, or to be
+		// a valid identifier, in which case it must be spelled out
+		if pkg.Alias == "" && imp.name != importPathName(pkg.URL) {
+			pkg.Alias = imp.name
+		}
+		resolved = append(resolved, pkg)
+	}
+	if err := WriteImports(outputWriter{o}, resolved...); err != nil {
+		return err
+	}
+	return o.err
 }
 
 func (o *Output) Write(dst io.Writer, options ...Option) error {
-	return Write(dst, o.src, options...)
+	src, err := o.source(options...)
+	if err != nil {
+		return err
+	}
+	return Write(dst, src, options...)
 }
 
 func (o *Output) WriteFile(fn string, options ...Option) error {
-	return WriteFile(fn, o.src, options...)
+	src, err := o.source(options...)
+	if err != nil {
+		return err
+	}
+	return WriteFile(fn, src, options...)
+}
+
+func (o *Output) CheckFile(fn string, options ...Option) error {
+	src, err := o.source(options...)
+	if err != nil {
+		return err
+	}
+	return CheckFile(fn, src, options...)
+}
+
+var rxPackageClause = regexp.MustCompile(`^package[ \t]+[^\s;]+$`)
+
+// findPackageClause returns the offsets of the beginning and the end of
+// the package clause in `src`. The source is tokenized, so that the word
+// "package" in comments or string literals is never mistaken for it
+func findPackageClause(src []byte) (int64, int64, bool) {
+	fset := token.NewFileSet()
+	file := fset.AddFile("", fset.Base(), len(src))
+
+	var s scanner.Scanner
+	s.Init(file, src, nil, 0)
+	pos, tok, _ := s.Scan()
+	if tok != token.PACKAGE {
+		return 0, 0, false
+	}
+	start := file.Offset(pos)
+
+	pos, tok, name := s.Scan()
+	if tok != token.IDENT {
+		return 0, 0, false
+	}
+	return int64(start), int64(file.Offset(pos) + len(name)), true
+}
+
+// source returns the reader containing the generated code, with
+// the contents of sections spliced in, the build constraints and
+// directives placed above the package clause, and the automatically
+// tracked imports inserted after the package clause
+func (o *Output) source(options ...Option) (io.Reader, error) {
+	if err := o.err; err != nil {
+		return nil, err
+	}
+
+	var legacyBuildTags bool
+	for _, option := range options {
+		switch option.Ident() {
+		case identLegacyBuildTags{}:
+			legacyBuildTags = option.Value().(bool)
+		}
+	}
+
+	constraints, err := o.renderBuildConstraint(legacyBuildTags)
+	if err != nil {
+		return nil, err
+	}
+	directives := o.renderDirectives()
+	block := o.imports.render()
+	if constraints == nil && directives == nil && block == nil && len(o.sectionOrder) == 0 {
+		buf, err := o.readSource()
+		if err != nil {
+			return nil, err
+		}
+		return bytes.NewReader(buf), nil
+	}
+
+	var extra []insertion
+	if constraints != nil {
+		extra = append(extra, insertion{offset: 0, data: constraints})
+	}
+
+	// the package clause recorded by WritePackage is only used if it is
+	// really there: otherwise code that was not written through the
+	// Output would shift the imports to the wrong place
+	buf, err := o.readSource()
+	if err != nil {
+		return nil, err
+	}
+	pkgKnown := o.pkgStart >= 0 && o.pkgEnd <= int64(len(buf)) &&
+		rxPackageClause.Match(buf[o.pkgStart:o.pkgEnd])
+	if pkgKnown {
+		extra = append(extra,
+			insertion{offset: o.pkgStart, data: directives},
+			insertion{offset: o.pkgEnd, data: block},
+		)
+	}
+
+	buf, err = o.render(extra...)
+	if err != nil {
+		return nil, err
+	}
+
+	if !pkgKnown && (directives != nil || block != nil) {
+		// WritePackage was not used, or the package clause is not where
+		// it was written, so look for it
+		start, end, ok := findPackageClause(buf)
+		if !ok {
+			return nil, fmt.Errorf(`failed to insert imports and directives: could not find package clause`)
+		}
+		buf = splice(buf, []insertion{
+			{offset: start, data: directives},
+			{offset: end, data: block},
+		})
+	}
+	return bytes.NewReader(buf), nil
 }
 
 func WriteImports(dst io.Writer, pkgs ...ImportPkg) error {
@@ -128,77 +477,260 @@ func WriteImports(dst io.Writer, pkgs ...ImportPkg) error {
 }
 
 func Write(dst io.Writer, src io.Reader, options ...Option) error {
+	buf, err := generate(src, options...)
+	if err != nil {
+		return err
+	}
+
+	_, err = dst.Write(buf)
+	return err
+}
+
+// generate reads the code from src, and runs it through the
+// transformations specified in options, in the following order:
+//
+//  1. the generated code header is inserted
+//  2. the code is formatted
+//  3. hand-written regions from the existing code are spliced in
+//  4. post processors are applied, in the order they were specified
+//  5. the code is type checked
+//  6. line numbers are added
+func generate(src io.Reader, options ...Option) ([]byte, error) {
 	var formatCode bool
 	var lineNumber bool
+	var generator string
+	var generatedFrom string
+	var fc formatConfig
+	var postProcessors []PostProcessor
+	var typeCheckCode bool
+	var keepRegions bool
+	var existing []byte
 	for _, option := range options {
 		switch option.Ident() {
 		case identFormatCode{}:
 			formatCode = option.Value().(bool)
+		case identFormatter{}:
+			fc.formatter = option.Value().(Formatter)
+		case identFilename{}:
+			fc.filename = option.Value().(string)
+		case identLocalPrefix{}:
+			fc.localPrefix = option.Value().(string)
+		case identFormatOnly{}:
+			fc.formatOnly = option.Value().(bool)
+		case identTabWidth{}:
+			fc.tabWidth = option.Value().(int)
+		case identPostProcessor{}:
+			postProcessors = append(postProcessors, option.Value().(PostProcessor))
+		case identTypeCheck{}:
+			typeCheckCode = option.Value().(bool)
+		case identKeepRegions{}:
+			keepRegions = option.Value().(bool)
+		case identExistingCode{}:
+			existing = option.Value().([]byte)
 		case identLineNumber{}:
 			lineNumber = option.Value().(bool)
+		case identGeneratedHeader{}:
+			generator = option.Value().(string)
+		case identGeneratedSource{}:
+			generatedFrom = option.Value().(string)
 		}
 	}
 
+	buf, err := ioutil.ReadAll(src)
+	if err != nil {
+		return nil, fmt.Errorf(`failed to read from source: %w`, err)
+	}
+
+	if generator != "" {
+		buf = addGeneratedHeader(buf, generator, generatedFrom)
+	}
+
 	if formatCode {
-		buf, err := ioutil.ReadAll(src)
+		formatted, err := fc.format(buf)
 		if err != nil {
-			return fmt.Errorf(`failed to read from source: %w`, err)
+			return nil, codeFormatError(err, buf)
 		}
+		buf = formatted
+	}
 
-		formatted, err := imports.Process("", buf, nil)
+	if keepRegions && existing != nil {
+		spliced, err := spliceKeepRegions(buf, existing)
 		if err != nil {
-			return codeFormatError(err, buf)
+			return nil, err
 		}
-
-		src = bytes.NewReader(formatted)
+		buf = spliced
 	}
 
-	if lineNumber {
-		// Count the number of lines, so we know how many digits to use
-		buf, err := ioutil.ReadAll(src)
+	for i, pp := range postProcessors {
+		processed, err := pp(buf)
 		if err != nil {
-			return fmt.Errorf(`failed to read from source: %w`, err)
+			return nil, fmt.Errorf(`post processor #%d failed: %w`, i+1, err)
 		}
+		buf = processed
+	}
 
-		digits := int(math.Log10(float64(bytes.Count(buf, []byte{'\n'})))) + 1
-		dstFmt := fmt.Sprintf("%%0%dd %%s\n", digits)
-		var dst bytes.Buffer
-		lineno := 1
-		for len(buf) > 0 {
-			l := bytes.Index(buf, []byte{'\n'})
-			if l == -1 {
-				l = len(buf)
-			}
-			fmt.Fprintf(&dst, dstFmt, lineno, buf[:l])
-			if l == len(buf) {
-				buf = nil
-			} else {
-				buf = buf[l+1:]
-			}
-			lineno++
+	if typeCheckCode {
+		if err := typeCheck(fc.filename, buf); err != nil {
+			return nil, err
 		}
+	}
 
-		src = &dst
+	if lineNumber {
+		buf = addLineNumbers(buf)
 	}
 
-	_, err := io.Copy(dst, src)
-	return err
+	return buf, nil
+}
+
+func addLineNumbers(buf []byte) []byte {
+	// Count the number of lines, so we know how many digits to use
+	digits := int(math.Log10(float64(bytes.Count(buf, []byte{'\n'})))) + 1
+	dstFmt := fmt.Sprintf("%%0%dd %%s\n", digits)
+	var dst bytes.Buffer
+	lineno := 1
+	for len(buf) > 0 {
+		l := bytes.Index(buf, []byte{'\n'})
+		if l == -1 {
+			l = len(buf)
+		}
+		fmt.Fprintf(&dst, dstFmt, lineno, buf[:l])
+		if l == len(buf) {
+			buf = nil
+		} else {
+			buf = buf[l+1:]
+		}
+		lineno++
+	}
+	return dst.Bytes()
 }
 
+// WriteFile writes the code read from src to the file `filename`,
+// after applying the transformations specified in options.
+//
+// The code is first written to a temporary file in the same directory,
+// which is then renamed to `filename`, so that an error will never leave
+// a truncated or partially written file behind. If the file already
+// exists with exactly the same contents, it is not touched, so that its
+// modification time is preserved.
+//
+// If WithDumpOnError is specified and the code cannot be formatted,
+// the unformatted code is written to `filename` + ".broken" instead.
+// Once the code is successfully written, a ".broken" file left over
+// from a previous attempt is removed.
+//
+// If WithManifest is specified, the file is recorded in the manifest
+// once it has been successfully written (or found to be up to date).
+//
+// Files are read from and written to the file system specified using
+// WithFS, which defaults to OSFS.
+//
+// If WithDryRun is specified, nothing is written to the file system.
+// Instead, the differences between the existing file and the newly
+// generated code are written out in the unified diff format
 func WriteFile(filename string, src io.Reader, options ...Option) error {
-	if dir := filepath.Dir(filename); dir != "." {
-		if _, err := os.Stat(dir); err != nil {
-			if err := os.MkdirAll(dir, 0755); err != nil {
-				return fmt.Errorf(`failed to create directory %q: %w`, dir, err)
-			}
+	var dryRun io.Writer
+	var dumpOnError bool
+	var manifest *Manifest
+	fs := fsFromOptions(options)
+	for _, option := range options {
+		switch option.Ident() {
+		case identDryRun{}:
+			dryRun = option.Value().(io.Writer)
+		case identDumpOnError{}:
+			dumpOnError = option.Value().(bool)
+		case identManifest{}:
+			manifest = option.Value().(*Manifest)
 		}
 	}
 
-	dst, err := os.Create(filename)
+	existing, exists, err := readExisting(fs, filename)
 	if err != nil {
-		return fmt.Errorf(`failed to open %s.go: %w`, filename, err)
+		return err
 	}
-	defer dst.Close()
 
-	return Write(dst, src, options...)
+	buf, err := generate(src, append([]Option{WithFilename(filename), withExistingCode(existing)}, options...)...)
+	if err != nil {
+		var cfe CodeFormatError
+		if dumpOnError && dryRun == nil && errors.As(err, &cfe) {
+			return dumpBrokenSource(fs, filename, cfe)
+		}
+		return err
+	}
+
+	if dryRun != nil {
+		oldName := filename
+		if !exists {
+			oldName = os.DevNull
+		}
+		if _, err := dryRun.Write(diff.Unified(oldName, filename, existing, buf)); err != nil {
+			return fmt.Errorf(`failed to write diff for %s: %w`, filename, err)
+		}
+		return nil
+	}
+
+	if !exists || !bytes.Equal(existing, buf) {
+		if err := fs.WriteFile(filename, buf); err != nil {
+			return fmt.Errorf(`failed to write %s: %w`, filename, err)
+		}
+	}
+
+	if dumpOnError {
+		// the dump from a previous attempt no longer reflects the code
+		if err := fs.Remove(filename + brokenSuffix); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf(`failed to remove unformatted source %s: %w`, filename+brokenSuffix, err)
+		}
+	}
+
+	if manifest != nil {
+		manifest.Add(filename, buf)
+	}
+	return nil
+}
+
+// CheckFile generates the code read from src in the same way as WriteFile,
+// and compares it against the contents of the file `filename`, without
+// modifying anything. If the file does not exist or its contents differ,
+// a StaleError is returned.
+//
+// This is useful for verifying that generated files have been
+// regenerated after the source they were generated from changed.
+func CheckFile(filename string, src io.Reader, options ...Option) error {
+	existing, exists, err := readExisting(fsFromOptions(options), filename)
+	if err != nil {
+		return err
+	}
+
+	buf, err := generate(src, append([]Option{WithFilename(filename), withExistingCode(existing)}, options...)...)
+	if err != nil {
+		return err
+	}
+
+	if !exists || !bytes.Equal(existing, buf) {
+		return staleError(filename)
+	}
+	return nil
+}
+
+// readExisting reads the current contents of `filename`. It is not an
+// error for the file not to exist, in which case `exists` is false
+func readExisting(fs FS, filename string) (data []byte, exists bool, err error) {
+	data, err = fs.ReadFile(filename)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, false, nil
+		}
+		return nil, false, fmt.Errorf(`failed to read existing file %s: %w`, filename, err)
+	}
+	return data, true, nil
+}
+
+// dumpBrokenSource writes the unformatted source to a file next to
+// `filename`, and returns the error with the name of that file
+func dumpBrokenSource(fs FS, filename string, cfe CodeFormatError) error {
+	dumpPath := filename + brokenSuffix
+	if err := fs.WriteFile(dumpPath, cfe.src); err != nil {
+		return fmt.Errorf(`failed to write unformatted source (%s): %w`, cfe.Error(), err)
+	}
+	cfe.dumpPath = dumpPath
+	return cfe
 }
@@ -1,13 +1,22 @@
 package codegen_test
 
 import (
+	"archive/zip"
 	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/lestrrat-go/codegen"
 	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v3"
 )
 
 func TestCodegen(t *testing.T) {
@@ -33,126 +42,2111 @@ func main() {
 			return
 		}
 	})
-	t.Run("InvalidCode", func(t *testing.T) {
+	t.Run("Block", func(t *testing.T) {
+		var src bytes.Buffer
+
+		o := codegen.NewOutput(&src)
+		o.R("package main")
+		o.L("")
+		o.Block("func main()", func() {
+			o.Block("for i := 0; i < 10; i++", func() {
+				o.L("if i%%2 == 0 {")
+				o.Indent()
+				o.L("continue")
+				o.Dedent()
+				o.L("}")
+				o.LL("println(i)")
+			})
+		})
+		o.Dedent() // extra Dedent() should be a no-op
+		o.LL("func foo() {}")
+
+		const expected = `package main
+
+func main() {
+	for i := 0; i < 10; i++ {
+		if i%2 == 0 {
+			continue
+		}
+
+		println(i)
+	}
+}
+
+func foo() {}`
+
+		if !assert.Equal(t, expected, src.String(), `output should match`) {
+			return
+		}
+	})
+	t.Run("Qual", func(t *testing.T) {
 		var dst, src bytes.Buffer
 
-		fmt.Fprintf(&src, "package main func main(){")
-		fmt.Fprintf(&src, "\n}")
+		o := codegen.NewOutput(&src)
+		o.WritePackage("main")
+		_ = o.WriteImportPkgs(codegen.ImportPkg{URL: "fmt"})
+		o.ImportAs("github.com/lestrrat-go/option", "opt")
+		o.LL("var _ %s", o.Qual("github.com/lestrrat-go/option", "Interface"))
+		o.LL("func main() {")
+		o.L("var u1 %s", o.Qual("net/url", "URL"))
+		o.L("var u2 %s", o.Qual("example.com/url", "URL"))
+		o.L("var n %s", o.Qual("gopkg.in/yaml.v3", "Node"))
+		o.L("%s(u1, u2, n)", o.Qual("fmt", "Println"))
+		o.L("}")
 
-		codegenErr := codegen.Write(&dst, &src, codegen.WithFormatCode(true))
-		if !assert.Error(t, codegenErr, `codegen.Write should fail`) {
+		if !assert.NoError(t, o.Write(&dst), `codegen.Write should succeed`) {
 			return
 		}
 
-		cfe, ok := codegenErr.(codegen.CodeFormatError)
-		if !assert.True(t, ok, `codegenErr should be codegen.CodeFormatError (got %T)`, codegenErr) {
+		const expected = `
+package main
+
+import (
+	"net/url"
+
+	url2 "example.com/url"
+	opt "github.com/lestrrat-go/option"
+	yaml "gopkg.in/yaml.v3"
+)
+import (
+"fmt"
+)
+
+var _ opt.Interface
+
+func main() {
+var u1 url.URL
+var u2 url2.URL
+var n yaml.Node
+fmt.Println(u1, u2, n)
+}`
+
+		if !assert.Equal(t, expected, dst.String(), `output should match`) {
 			return
 		}
+	})
+	t.Run("ImportNames", func(t *testing.T) {
+		t.Run("AliasClash", func(t *testing.T) {
+			o := codegen.NewOutput(&bytes.Buffer{})
+			o.Qual("net/url", "URL")
+			o.ImportAs("example.com/a", "url")
+			if !assert.Error(t, o.Err(), `ImportAs should fail when the alias is taken`) {
+				return
+			}
+		})
+		t.Run("AliasAfterQual", func(t *testing.T) {
+			o := codegen.NewOutput(&bytes.Buffer{})
+			o.Qual("net/url", "URL")
+			o.ImportAs("net/url", "stdurl")
+			if !assert.Error(t, o.Err(), `ImportAs should fail when the package already has a name`) {
+				return
+			}
+		})
+		t.Run("WriteImportPkgs", func(t *testing.T) {
+			var src bytes.Buffer
+			o := codegen.NewOutput(&src)
+			o.Qual("net/url", "URL")
+			err := o.WriteImportPkgs(codegen.ImportPkg{Alias: "stdurl", URL: "net/url"})
+			if !assert.Error(t, err, `WriteImportPkgs should fail when the alias differs from the name in use`) {
+				return
+			}
 
-		const expected = `1: package main func main(){
-2: }
-`
-		if !assert.Equal(t, expected, cfe.Source(), `cfe.Source should match`) {
+			o.Qual("example.com/url", "URL")
+			if !assert.NoError(t, o.WriteImportPkgs(codegen.ImportPkg{URL: "example.com/url"}), `WriteImportPkgs should succeed`) {
+				return
+			}
+			if !assert.Equal(t, "\nimport (\nurl2 \"example.com/url\"\n)", src.String(), `the name in use should be written as the alias`) {
+				return
+			}
+		})
+		t.Run("DotImport", func(t *testing.T) {
+			var src, dst bytes.Buffer
+			o := codegen.NewOutput(&src)
+			o.WritePackage("main")
+			o.ImportAs("strings", ".")
+			o.LL("var _ = %s", o.Qual("strings", "ToUpper"))
+			if !assert.NoError(t, o.Write(&dst), `Write should succeed`) {
+				return
+			}
+			if !assert.Contains(t, dst.String(), `. "strings"`, `import should use the dot alias`) {
+				return
+			}
+			if !assert.Contains(t, dst.String(), `var _ = ToUpper`, `name should not be qualified`) {
+				return
+			}
+		})
+		t.Run("BlankImport", func(t *testing.T) {
+			o := codegen.NewOutput(&bytes.Buffer{})
+			o.WritePackage("main")
+			o.ImportAs("embed", "_")
+			if !assert.NoError(t, o.Err(), `ImportAs should succeed`) {
+				return
+			}
+			o.LL("var _ %s", o.Qual("embed", "FS"))
+			if !assert.Error(t, o.Err(), `Qual should fail for a blank import`) {
+				return
+			}
+			if !assert.Error(t, o.Write(&bytes.Buffer{}), `Write should fail`) {
+				return
+			}
+		})
+		t.Run("InvalidIdentifiers", func(t *testing.T) {
+			o := codegen.NewOutput(&bytes.Buffer{})
+			for path, expected := range map[string]string{
+				"example.com/go":     "go_.X",
+				"example.com/type":   "type_.X",
+				"example.com/123abc": "_123abc.X",
+				"example.com/-foo":   "pkg.X",
+			} {
+				if !assert.Equal(t, expected, o.Qual(path, "X"), `qualified name for %q should match`, path) {
+					return
+				}
+			}
+
+			var src, dst bytes.Buffer
+			o = codegen.NewOutput(&src)
+			o.WritePackage("main")
+			o.L("var _ = %s", o.Qual("example.com/go", "X"))
+			if !assert.NoError(t, o.Write(&dst), `Write should succeed`) {
+				return
+			}
+			if !assert.Contains(t, dst.String(), `go_ "example.com/go"`, `import should be aliased`) {
+				return
+			}
+		})
+	})
+	t.Run("ForeignBytes", func(t *testing.T) {
+		const expected = `// header
+
+package main
+
+import (
+	"fmt"
+)
+
+var _ = fmt.Sprint`
+
+		t.Run("PackageFunctions", func(t *testing.T) {
+			var src, dst bytes.Buffer
+			o := codegen.NewOutput(&src)
+			codegen.R(&src, "// header\n")
+			o.WritePackage("main")
+			o.LL("var _ = %s", o.Qual("fmt", "Sprint"))
+			if !assert.NoError(t, o.Write(&dst), `Write should succeed`) {
+				return
+			}
+			if !assert.Equal(t, expected, dst.String(), `imports should follow the package clause`) {
+				return
+			}
+		})
+		t.Run("PreexistingContent", func(t *testing.T) {
+			var dst bytes.Buffer
+			o := codegen.NewOutput(bytes.NewBufferString("// header\n"))
+			o.WritePackage("main")
+			o.LL("var _ = %s", o.Qual("fmt", "Sprint"))
+			if !assert.NoError(t, o.Write(&dst), `Write should succeed`) {
+				return
+			}
+			if !assert.Equal(t, expected, dst.String(), `imports should follow the package clause`) {
+				return
+			}
+		})
+		t.Run("UnknownLength", func(t *testing.T) {
+			// the destination does not report its length, so the
+			// package clause has to be looked up
+			var buf bytes.Buffer
+			var dst bytes.Buffer
+			o := codegen.NewOutput(struct {
+				io.Reader
+				io.Writer
+			}{&buf, &buf})
+			codegen.R(&buf, "// header, not a package clause\n")
+			o.WritePackage("main")
+			o.LL("var _ = %s", o.Qual("fmt", "Sprint"))
+			if !assert.NoError(t, o.Write(&dst), `Write should succeed`) {
+				return
+			}
+			if !assert.Equal(t, strings.Replace(expected, "header", "header, not a package clause", 1), dst.String(), `imports should follow the package clause`) {
+				return
+			}
+		})
+	})
+	t.Run("WriteTwice", func(t *testing.T) {
+		o := codegen.NewOutput(&bytes.Buffer{})
+		o.WritePackage("main")
+		o.LL("var _ = %s", o.Qual("fmt", "Sprint"))
+
+		var first, second bytes.Buffer
+		if !assert.NoError(t, o.Write(&first), `first Write should succeed`) {
+			return
+		}
+		if !assert.NoError(t, o.Write(&second), `second Write should succeed`) {
+			return
+		}
+		if !assert.Equal(t, first.String(), second.String(), `output should be the same`) {
+			return
+		}
+
+		o.L("var _ = 1")
+		var third bytes.Buffer
+		if !assert.NoError(t, o.Write(&third), `third Write should succeed`) {
+			return
+		}
+		if !assert.Equal(t, second.String()+"\nvar _ = 1", third.String(), `code written after Write should be included`) {
 			return
 		}
 	})
-	t.Run("WriteImports", func(t *testing.T) {
+	t.Run("Sections", func(t *testing.T) {
 		var dst, src bytes.Buffer
 
 		o := codegen.NewOutput(&src)
 		o.WritePackage("main")
-		pkgs := []codegen.ImportPkg{
-			{
-				URL: "github.com/lestrrat-go/codegen",
-			},
-			{
-				Alias: "stdliburl",
-				URL:   "net/url",
-			},
-		}
-		_ = o.WriteImportPkgs(pkgs...)
-
-		o.LL("func main(){")
-		o.L("var u stdliburl.URL")
-		o.L("var o codegen.Output")
+		types := o.Reserve("types")
+		o.LL("func main() {")
+		o.Indent()
+		vars := o.Reserve("vars")
+		o.L("%s(a, b)", o.Qual("fmt", "Println"))
+		o.Dedent()
 		o.L("}")
 
-		if !assert.NoError(t, o.Write(&dst, codegen.WithFormatCode(true)), `codegen.Write should succeed`) {
+		// fill the sections after the body has been written
+		o.Section("vars").L("a := 1")
+		vars.L("b := %s", o.Qual("strings", "Repeat(\"b\", 2)"))
+		types.LL("type Foo struct{}")
+
+		if !assert.NoError(t, o.Write(&dst), `codegen.Write should succeed`) {
 			return
 		}
 
-		const expected = `package main
+		const expected = `
+package main
 
 import (
-	stdliburl "net/url"
-
-	"github.com/lestrrat-go/codegen"
+	"fmt"
+	"strings"
 )
 
+type Foo struct{}
+
 func main() {
-	var u stdliburl.URL
-	var o codegen.Output
-}
-`
+	a := 1
+	b := strings.Repeat("b", 2)
+	fmt.Println(a, b)
+}`
 
 		if !assert.Equal(t, expected, dst.String(), `output should match`) {
 			return
 		}
 	})
-}
+	t.Run("SectionOrder", func(t *testing.T) {
+		var dst bytes.Buffer
+		o := codegen.NewOutput(&bytes.Buffer{})
+		o.WritePackage("main")
+		o.Section("b").LL("// b")
+		o.Reserve("a").LL("// a")
+		o.Reserve("b")
 
-func TestObject(t *testing.T) {
-	var _ codegen.Field = &codegen.ConstantField{}
+		if !assert.NoError(t, o.Write(&dst), `codegen.Write should succeed`) {
+			return
+		}
+		if !assert.Equal(t, "\npackage main\n\n// a\n\n// b", dst.String(), `sections should appear in the order they were reserved`) {
+			return
+		}
+	})
+	t.Run("SectionForeignBytes", func(t *testing.T) {
+		t.Run("Buffer", func(t *testing.T) {
+			var src, dst bytes.Buffer
+			o := codegen.NewOutput(&src)
+			codegen.R(&src, "// header\n")
+			o.WritePackage("main")
+			o.Reserve("vars").LL("var x int")
+			o.LL("func main() {}")
 
-	testcases := []struct {
-		Name   string
-		Input  string
-		Verify func(*testing.T, *codegen.ConstantField)
-	}{
-		{
-			Name:  `Simple`,
-			Input: `{"name": "Field1"}`,
-			Verify: func(t *testing.T, f *codegen.ConstantField) {
-				if !assert.Equal(t, "Field1", f.Name(true), `name should match`) {
-					return
-				}
+			if !assert.NoError(t, o.Write(&dst), `codegen.Write should succeed`) {
+				return
+			}
+			if !assert.Equal(t, "// header\n\npackage main\n\nvar x int\n\nfunc main() {}", dst.String(), `section should be spliced in at the reserved position`) {
+				return
+			}
+		})
+		t.Run("UnknownLength", func(t *testing.T) {
+			var buf bytes.Buffer
+			o := codegen.NewOutput(struct {
+				io.Reader
+				io.Writer
+			}{&buf, &buf})
+			codegen.R(&buf, "// header\n")
+			o.WritePackage("main")
+			o.Reserve("vars").LL("var x int")
 
-				if !assert.Equal(t, "field1", f.Name(false), `unexported name should match`) {
-					return
-				}
+			if !assert.Error(t, o.Write(&bytes.Buffer{}), `codegen.Write should fail`) {
+				return
+			}
+		})
+	})
+	t.Run("UnreservedSection", func(t *testing.T) {
+		var dst, src bytes.Buffer
 
-				if !assert.Equal(t, "string", f.Type(), `type should match`) {
-					return
-				}
-			},
-		},
-		{
-			Name:  `separate name, exported name, and unexported name`,
-			Input: `{"name": "Field1", "exported_name": "Foo", "unexported_name": "bar"}`,
-			Verify: func(t *testing.T, f *codegen.ConstantField) {
-				if !assert.Equal(t, "Foo", f.Name(true), `name should match`) {
-					return
-				}
+		o := codegen.NewOutput(&src)
+		o.WritePackage("main")
+		o.Section("missing").L("var x int")
 
-				if !assert.Equal(t, "bar", f.Name(false), `unexported name should match`) {
-					return
-				}
+		if !assert.Error(t, o.Write(&dst), `codegen.Write should fail`) {
+			return
+		}
+	})
+	t.Run("GeneratedHeader", func(t *testing.T) {
+		const expected = `// Code generated by mygen from spec.json; DO NOT EDIT.
 
-				if !assert.Equal(t, "string", f.Type(), `type should match`) {
-					return
-				}
-			},
-		},
-	}
-	for _, tc := range testcases {
-		tc := tc
-		t.Run(tc.Name, func(t *testing.T) {
-			var field codegen.ConstantField
-			if !assert.NoError(t, json.Unmarshal([]byte(tc.Input), &field), `json.Unmarshal should succeed`) {
+package main
+`
+
+		t.Run("Option", func(t *testing.T) {
+			var dst, src bytes.Buffer
+
+			o := codegen.NewOutput(&src)
+			o.WritePackage("main")
+
+			err := o.Write(&dst,
+				codegen.WithFormatCode(true),
+				codegen.WithGeneratedHeader("mygen"),
+				codegen.WithGeneratedSource("spec.json"),
+			)
+			if !assert.NoError(t, err, `codegen.Write should succeed`) {
+				return
+			}
+
+			if !assert.Equal(t, expected, dst.String(), `output should match`) {
 				return
 			}
-			field.Organize()
-			tc.Verify(t, &field)
 		})
-	}
+		t.Run("Output", func(t *testing.T) {
+			var dst, src bytes.Buffer
+
+			o := codegen.NewOutput(&src)
+			o.WriteGeneratedHeader("mygen", "spec.json")
+			o.WritePackage("main")
+
+			// the header should not be inserted twice
+			err := o.Write(&dst,
+				codegen.WithFormatCode(true),
+				codegen.WithGeneratedHeader("othergen"),
+			)
+			if !assert.NoError(t, err, `codegen.Write should succeed`) {
+				return
+			}
+
+			if !assert.Equal(t, expected, dst.String(), `output should match`) {
+				return
+			}
+			if !assert.True(t, codegen.IsGenerated(dst.Bytes()), `codegen.IsGenerated should be true`) {
+				return
+			}
+		})
+	})
+	t.Run("BuildConstraint", func(t *testing.T) {
+		var dst, src bytes.Buffer
+
+		o := codegen.NewOutput(&src)
+		o.WriteGeneratedHeader("mygen", "")
+		o.BuildConstraint("linux || darwin")
+		o.BuildConstraint("!cgo")
+		o.Directive("go:generate mygen")
+		o.Directive("//nolint:all")
+		o.WritePackage("main")
+		o.LL("func main() {")
+		o.L("%s()", o.Qual("fmt", "Println"))
+		o.L("}")
+
+		err := o.Write(&dst,
+			codegen.WithFormatCode(true),
+			codegen.WithLegacyBuildTags(true),
+		)
+		if !assert.NoError(t, err, `codegen.Write should succeed`) {
+			return
+		}
+
+		const expected = `//go:build (linux || darwin) && !cgo
+// +build linux darwin
+// +build !cgo
+
+// Code generated by mygen; DO NOT EDIT.
+
+//go:generate mygen
+//nolint:all
+package main
+
+import (
+	"fmt"
+)
+
+func main() {
+	fmt.Println()
+}
+`
+		if !assert.Equal(t, expected, dst.String(), `output should match`) {
+			return
+		}
+	})
+	t.Run("InvalidBuildConstraint", func(t *testing.T) {
+		var dst, src bytes.Buffer
+
+		o := codegen.NewOutput(&src)
+		o.BuildConstraint("linux &&")
+		if !assert.Error(t, o.Err(), `o.Err should not be nil`) {
+			return
+		}
+		if !assert.Error(t, o.Write(&dst), `o.Write should fail`) {
+			return
+		}
+	})
+	t.Run("Formatter", func(t *testing.T) {
+		const input = `package main
+import (
+"fmt"
+"github.com/lestrrat-go/codegen"
+"github.com/stretchr/testify/assert"
+"os"
+)
+func main() {
+fmt.Println(codegen.Output{}, assert.Equal, strings.Repeat)
+}`
+		testcases := []struct {
+			Name     string
+			Options  []codegen.Option
+			Expected string
+		}{
+			{
+				Name:    "gofmt",
+				Options: []codegen.Option{codegen.WithFormatter(codegen.FormatGofmt)},
+				Expected: `package main
+
+import (
+	"fmt"
+	"github.com/lestrrat-go/codegen"
+	"github.com/stretchr/testify/assert"
+	"os"
+)
+
+func main() {
+	fmt.Println(codegen.Output{}, assert.Equal, strings.Repeat)
+}
+`,
+			},
+			{
+				Name: "goimports with local prefix",
+				Options: []codegen.Option{
+					codegen.WithFormatOnly(true),
+					codegen.WithLocalPrefix("github.com/lestrrat-go"),
+				},
+				Expected: `package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/lestrrat-go/codegen"
+)
+
+func main() {
+	fmt.Println(codegen.Output{}, assert.Equal, strings.Repeat)
+}
+`,
+			},
+			{
+				Name: "goimports",
+				Expected: `package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/lestrrat-go/codegen"
+	"github.com/stretchr/testify/assert"
+)
+
+func main() {
+	fmt.Println(codegen.Output{}, assert.Equal, strings.Repeat)
+}
+`,
+			},
+		}
+		for _, tc := range testcases {
+			tc := tc
+			t.Run(tc.Name, func(t *testing.T) {
+				var dst bytes.Buffer
+				options := append([]codegen.Option{codegen.WithFormatCode(true)}, tc.Options...)
+				if !assert.NoError(t, codegen.Write(&dst, strings.NewReader(input), options...), `codegen.Write should succeed`) {
+					return
+				}
+
+				if !assert.Equal(t, tc.Expected, dst.String(), `output should match`) {
+					return
+				}
+			})
+		}
+	})
+	t.Run("PostProcessor", func(t *testing.T) {
+		license := func(src []byte) ([]byte, error) {
+			return append([]byte("// Copyright (c) example.com\n\n"), src...), nil
+		}
+		rename := func(src []byte) ([]byte, error) {
+			return bytes.ReplaceAll(src, []byte("foo"), []byte("bar")), nil
+		}
+
+		t.Run("Success", func(t *testing.T) {
+			var dst bytes.Buffer
+			err := codegen.Write(&dst, strings.NewReader("package foo\nfunc foo(){}"),
+				codegen.WithFormatCode(true),
+				codegen.WithPostProcessor(license),
+				codegen.WithPostProcessor(rename),
+				codegen.WithLineNumber(true),
+			)
+			if !assert.NoError(t, err, `codegen.Write should succeed`) {
+				return
+			}
+
+			const expected = `1 // Copyright (c) example.com
+2 
+3 package bar
+4 
+5 func bar() {}
+`
+			if !assert.Equal(t, expected, dst.String(), `output should match`) {
+				return
+			}
+		})
+		t.Run("Error", func(t *testing.T) {
+			errLint := errors.New(`lint failed`)
+			lint := func([]byte) ([]byte, error) {
+				return nil, errLint
+			}
+
+			var dst bytes.Buffer
+			err := codegen.Write(&dst, strings.NewReader("package foo"),
+				codegen.WithPostProcessor(license),
+				codegen.WithPostProcessor(lint),
+			)
+			if !assert.Error(t, err, `codegen.Write should fail`) {
+				return
+			}
+			if !assert.True(t, errors.Is(err, errLint), `error should wrap the original error`) {
+				return
+			}
+			if !assert.Contains(t, err.Error(), `post processor #2`, `error should identify the failing post processor`) {
+				return
+			}
+		})
+	})
+	t.Run("InvalidCode", func(t *testing.T) {
+		var dst, src bytes.Buffer
+
+		fmt.Fprintf(&src, "package main func main(){")
+		fmt.Fprintf(&src, "\n}")
+
+		codegenErr := codegen.Write(&dst, &src, codegen.WithFormatCode(true))
+		if !assert.Error(t, codegenErr, `codegen.Write should fail`) {
+			return
+		}
+
+		cfe, ok := codegenErr.(codegen.CodeFormatError)
+		if !assert.True(t, ok, `codegenErr should be codegen.CodeFormatError (got %T)`, codegenErr) {
+			return
+		}
+
+		const expected = `1: package main func main(){
+2: }
+`
+		if !assert.Equal(t, expected, cfe.Source(), `cfe.Source should match`) {
+			return
+		}
+	})
+	t.Run("InvalidCodePositions", func(t *testing.T) {
+		var dst bytes.Buffer
+
+		const input = "package main\n\nfunc main() {\n\tx := 1\n\tif x == {\n\t}\n}\n\nfunc foo() {}\n"
+		codegenErr := codegen.Write(&dst, strings.NewReader(input), codegen.WithFormatCode(true))
+		if !assert.Error(t, codegenErr, `codegen.Write should fail`) {
+			return
+		}
+
+		var cfe codegen.CodeFormatError
+		if !assert.True(t, errors.As(codegenErr, &cfe), `errors.As should succeed`) {
+			return
+		}
+		if !assert.NotNil(t, errors.Unwrap(cfe), `errors.Unwrap should return the formatter error`) {
+			return
+		}
+
+		positions := cfe.Positions()
+		if !assert.NotEmpty(t, positions, `cfe.Positions should not be empty`) {
+			return
+		}
+		if !assert.Equal(t, 5, positions[0].Line, `line should match`) {
+			return
+		}
+		if !assert.Equal(t, 10, positions[0].Column, `column should match`) {
+			return
+		}
+
+		if !assert.Len(t, positions, 2, `there should be 2 errors`) {
+			return
+		}
+
+		expected := "04: \tx := 1\n05: \tif x == {\n    \t        ^ " + positions[0].Message + "\n06: \t}\n" +
+			"\n08: \n09: func foo() {}\n                 ^ " + positions[1].Message + "\n10: \n"
+		if !assert.Equal(t, expected, cfe.Snippet(1), `cfe.Snippet should match`) {
+			return
+		}
+	})
+	t.Run("WriteImports", func(t *testing.T) {
+		var dst, src bytes.Buffer
+
+		o := codegen.NewOutput(&src)
+		o.WritePackage("main")
+		pkgs := []codegen.ImportPkg{
+			{
+				URL: "github.com/lestrrat-go/codegen",
+			},
+			{
+				Alias: "stdliburl",
+				URL:   "net/url",
+			},
+		}
+		_ = o.WriteImportPkgs(pkgs...)
+
+		o.LL("func main(){")
+		o.L("var u stdliburl.URL")
+		o.L("var o codegen.Output")
+		o.L("}")
+
+		if !assert.NoError(t, o.Write(&dst, codegen.WithFormatCode(true)), `codegen.Write should succeed`) {
+			return
+		}
+
+		const expected = `package main
+
+import (
+	stdliburl "net/url"
+
+	"github.com/lestrrat-go/codegen"
+)
+
+func main() {
+	var u stdliburl.URL
+	var o codegen.Output
+}
+`
+
+		if !assert.Equal(t, expected, dst.String(), `output should match`) {
+			return
+		}
+	})
+}
+
+type limitedWriter struct {
+	bytes.Buffer
+	limit int
+}
+
+func (w *limitedWriter) Write(p []byte) (int, error) {
+	if w.Len()+len(p) > w.limit {
+		return 0, errors.New(`write limit exceeded`)
+	}
+	return w.Buffer.Write(p)
+}
+
+func (w *limitedWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+func TestOutputError(t *testing.T) {
+	var dst bytes.Buffer
+
+	src := &limitedWriter{limit: 20}
+	o := codegen.NewOutput(src)
+	o.WritePackage("main")
+	if !assert.NoError(t, o.Err(), `o.Err should be nil`) {
+		return
+	}
+
+	o.LL("func main() {")
+	if !assert.Error(t, o.Err(), `o.Err should not be nil`) {
+		return
+	}
+	firstErr := o.Err()
+
+	o.L("}") // would fit within the limit, but should be discarded
+	if !assert.Equal(t, firstErr, o.Err(), `o.Err should return the first error`) {
+		return
+	}
+
+	if !assert.Equal(t, "\npackage main", src.String(), `writes after the error should be discarded`) {
+		return
+	}
+
+	if !assert.True(t, errors.Is(o.Write(&dst), firstErr), `o.Write should return the same error`) {
+		return
+	}
+	if !assert.Equal(t, 0, dst.Len(), `nothing should be written`) {
+		return
+	}
+}
+
+func TestWriteFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "codegen-test-")
+	if !assert.NoError(t, err, `ioutil.TempDir should succeed`) {
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, "sub", "foo_gen.go")
+	const valid = "package foo\nfunc Foo() {}"
+	const expected = "package foo\n\nfunc Foo() {}\n"
+
+	if !assert.NoError(t, codegen.WriteFile(filename, strings.NewReader(valid), codegen.WithFormatCode(true)), `codegen.WriteFile should succeed`) {
+		return
+	}
+
+	written, err := ioutil.ReadFile(filename)
+	if !assert.NoError(t, err, `ioutil.ReadFile should succeed`) {
+		return
+	}
+	if !assert.Equal(t, expected, string(written), `file contents should match`) {
+		return
+	}
+
+	t.Run("Unchanged", func(t *testing.T) {
+		past := time.Now().Add(-time.Hour).Truncate(time.Second)
+		if !assert.NoError(t, os.Chtimes(filename, past, past), `os.Chtimes should succeed`) {
+			return
+		}
+
+		if !assert.NoError(t, codegen.WriteFile(filename, strings.NewReader(valid), codegen.WithFormatCode(true)), `codegen.WriteFile should succeed`) {
+			return
+		}
+
+		fi, err := os.Stat(filename)
+		if !assert.NoError(t, err, `os.Stat should succeed`) {
+			return
+		}
+		if !assert.True(t, fi.ModTime().Equal(past), `modification time should not change`) {
+			return
+		}
+	})
+	t.Run("DryRun", func(t *testing.T) {
+		var dst bytes.Buffer
+		err := codegen.WriteFile(filename, strings.NewReader("package foo\nfunc Bar() {}"),
+			codegen.WithFormatCode(true),
+			codegen.WithDryRun(&dst),
+		)
+		if !assert.NoError(t, err, `codegen.WriteFile should succeed`) {
+			return
+		}
+
+		expectedDiff := "--- " + filename + "\n+++ " + filename + "\n@@ -1,3 +1,3 @@\n package foo\n \n-func Foo() {}\n+func Bar() {}\n"
+		if !assert.Equal(t, expectedDiff, dst.String(), `diff should match`) {
+			return
+		}
+
+		written, err := ioutil.ReadFile(filename)
+		if !assert.NoError(t, err, `ioutil.ReadFile should succeed`) {
+			return
+		}
+		if !assert.Equal(t, expected, string(written), `file should be left untouched`) {
+			return
+		}
+	})
+	t.Run("CheckFile", func(t *testing.T) {
+		if !assert.NoError(t, codegen.CheckFile(filename, strings.NewReader(valid), codegen.WithFormatCode(true)), `codegen.CheckFile should succeed`) {
+			return
+		}
+
+		for _, fn := range []string{filename, filepath.Join(dir, "missing.go")} {
+			err := codegen.CheckFile(fn, strings.NewReader("package foo\nfunc Bar() {}"), codegen.WithFormatCode(true))
+			if !assert.Error(t, err, `codegen.CheckFile should fail`) {
+				return
+			}
+
+			var staleErr codegen.StaleError
+			if !assert.True(t, errors.As(err, &staleErr), `error should be codegen.StaleError (got %T)`, err) {
+				return
+			}
+			if !assert.Equal(t, []string{fn}, staleErr.Files(), `stale files should match`) {
+				return
+			}
+		}
+	})
+	t.Run("FormatError", func(t *testing.T) {
+		err := codegen.WriteFile(filename, strings.NewReader("package foo func"), codegen.WithFormatCode(true))
+		if !assert.Error(t, err, `codegen.WriteFile should fail`) {
+			return
+		}
+
+		written, err := ioutil.ReadFile(filename)
+		if !assert.NoError(t, err, `ioutil.ReadFile should succeed`) {
+			return
+		}
+		if !assert.Equal(t, expected, string(written), `file should be left untouched`) {
+			return
+		}
+
+		entries, err := ioutil.ReadDir(filepath.Dir(filename))
+		if !assert.NoError(t, err, `ioutil.ReadDir should succeed`) {
+			return
+		}
+		if !assert.Len(t, entries, 1, `no temporary files should be left behind`) {
+			return
+		}
+	})
+	t.Run("DumpOnError", func(t *testing.T) {
+		const broken = "package foo func"
+		err := codegen.WriteFile(filename, strings.NewReader(broken),
+			codegen.WithFormatCode(true),
+			codegen.WithDumpOnError(true),
+		)
+		if !assert.Error(t, err, `codegen.WriteFile should fail`) {
+			return
+		}
+
+		var cfe codegen.CodeFormatError
+		if !assert.True(t, errors.As(err, &cfe), `error should be codegen.CodeFormatError (got %T)`, err) {
+			return
+		}
+		if !assert.Equal(t, filename+".broken", cfe.DumpPath(), `dump path should match`) {
+			return
+		}
+		if !assert.Contains(t, err.Error(), cfe.DumpPath(), `error message should contain the dump path`) {
+			return
+		}
+		defer os.Remove(cfe.DumpPath())
+
+		dumped, err := ioutil.ReadFile(cfe.DumpPath())
+		if !assert.NoError(t, err, `ioutil.ReadFile should succeed`) {
+			return
+		}
+		if !assert.Equal(t, broken, string(dumped), `dumped source should match`) {
+			return
+		}
+
+		written, err := ioutil.ReadFile(filename)
+		if !assert.NoError(t, err, `ioutil.ReadFile should succeed`) {
+			return
+		}
+		if !assert.Equal(t, expected, string(written), `file should be left untouched`) {
+			return
+		}
+
+		err = codegen.WriteFile(filename, strings.NewReader(valid),
+			codegen.WithFormatCode(true),
+			codegen.WithDumpOnError(true),
+		)
+		if !assert.NoError(t, err, `codegen.WriteFile should succeed`) {
+			return
+		}
+		if _, err := os.Stat(cfe.DumpPath()); !assert.True(t, os.IsNotExist(err), `dumped source should be removed after a successful write`) {
+			return
+		}
+	})
+}
+
+func TestTypeCheck(t *testing.T) {
+	dir, err := ioutil.TempDir("", "codegen-test-")
+	if !assert.NoError(t, err, `ioutil.TempDir should succeed`) {
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	const sibling = "package foo\n\nconst Answer = 42\n"
+	if !assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "sibling.go"), []byte(sibling), 0644), `ioutil.WriteFile should succeed`) {
+		return
+	}
+
+	filename := filepath.Join(dir, "foo_gen.go")
+	t.Run("Success", func(t *testing.T) {
+		const src = "package foo\n\nimport \"fmt\"\n\nfunc Foo() string {\n\treturn fmt.Sprint(Answer)\n}\n"
+		if !assert.NoError(t, codegen.WriteFile(filename, strings.NewReader(src), codegen.WithTypeCheck(true)), `codegen.WriteFile should succeed`) {
+			return
+		}
+	})
+	t.Run("Failure", func(t *testing.T) {
+		const src = "package foo\n\nfunc Foo() int {\n\treturn Undefined + Answer\n}\n"
+		err := codegen.WriteFile(filename, strings.NewReader(src), codegen.WithTypeCheck(true))
+		if !assert.Error(t, err, `codegen.WriteFile should fail`) {
+			return
+		}
+
+		var tce codegen.TypeCheckError
+		if !assert.True(t, errors.As(err, &tce), `error should be codegen.TypeCheckError (got %T)`, err) {
+			return
+		}
+
+		positions := tce.Positions()
+		if !assert.Len(t, positions, 1, `there should be 1 error`) {
+			return
+		}
+
+		if !assert.Equal(t, codegen.ErrorPosition{Filename: filename, Line: 4, Column: 9, Message: "undefined: Undefined"}, positions[0], `position should match`) {
+			return
+		}
+
+		const expected = "3: func Foo() int {\n4: \treturn Undefined + Answer\n   \t       ^ undefined: Undefined\n5: }\n"
+		if !assert.Equal(t, expected, tce.Snippet(1), `tce.Snippet should match`) {
+			return
+		}
+	})
+}
+
+func TestKeepRegions(t *testing.T) {
+	dir, err := ioutil.TempDir("", "codegen-test-")
+	if !assert.NoError(t, err, `ioutil.TempDir should succeed`) {
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, "foo_gen.go")
+	const existing = `package foo
+
+func Foo() int {
+	// codegen:keep begin foo
+	x := 42
+	return x
+	// codegen:keep end foo
+}
+`
+	if !assert.NoError(t, ioutil.WriteFile(filename, []byte(existing), 0644), `ioutil.WriteFile should succeed`) {
+		return
+	}
+
+	t.Run("Preserve", func(t *testing.T) {
+		const generated = `package foo
+
+func Foo() int {
+	// codegen:keep begin foo
+	return 0
+	// codegen:keep end foo
+}
+
+func Bar() int {
+	// codegen:keep begin bar
+	return 0
+	// codegen:keep end
+}
+`
+		options := []codegen.Option{codegen.WithFormatCode(true), codegen.WithKeepRegions(true)}
+		if !assert.NoError(t, codegen.WriteFile(filename, strings.NewReader(generated), options...), `codegen.WriteFile should succeed`) {
+			return
+		}
+
+		const expected = `package foo
+
+func Foo() int {
+	// codegen:keep begin foo
+	x := 42
+	return x
+	// codegen:keep end foo
+}
+
+func Bar() int {
+	// codegen:keep begin bar
+	return 0
+	// codegen:keep end
+}
+`
+		written, err := ioutil.ReadFile(filename)
+		if !assert.NoError(t, err, `ioutil.ReadFile should succeed`) {
+			return
+		}
+		if !assert.Equal(t, expected, string(written), `file contents should match`) {
+			return
+		}
+
+		if !assert.NoError(t, codegen.CheckFile(filename, strings.NewReader(generated), options...), `codegen.CheckFile should succeed`) {
+			return
+		}
+	})
+	t.Run("Orphaned", func(t *testing.T) {
+		const generated = "package foo\n\nfunc Foo() int {\n\treturn 0\n}\n"
+		err := codegen.WriteFile(filename, strings.NewReader(generated), codegen.WithKeepRegions(true))
+		if !assert.Error(t, err, `codegen.WriteFile should fail`) {
+			return
+		}
+		if !assert.Contains(t, err.Error(), `"foo"`, `error should mention the orphaned region`) {
+			return
+		}
+	})
+	t.Run("Unbalanced", func(t *testing.T) {
+		const generated = "package foo\n\n// codegen:keep begin foo\n"
+		err := codegen.WriteFile(filename, strings.NewReader(generated), codegen.WithKeepRegions(true))
+		if !assert.Error(t, err, `codegen.WriteFile should fail`) {
+			return
+		}
+	})
+}
+
+func TestManifest(t *testing.T) {
+	dir, err := ioutil.TempDir("", "codegen-test-")
+	if !assert.NoError(t, err, `ioutil.TempDir should succeed`) {
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	manifestFile := filepath.Join(dir, "manifest.json")
+	foo := filepath.Join(dir, "foo_gen.go")
+	bar := filepath.Join(dir, "bar_gen.go")
+	handwritten := filepath.Join(dir, "baz.go")
+	edited := filepath.Join(dir, "edited_gen.go")
+	literal := filepath.Join(dir, "literal.go")
+
+	// first run: generates foo, bar, baz, and edited
+	first := codegen.NewManifest()
+	for _, fn := range []string{foo, bar, handwritten, edited} {
+		err := codegen.WriteFile(fn, strings.NewReader("package foo\n"),
+			codegen.WithGeneratedHeader("mygen"),
+			codegen.WithManifest(first),
+		)
+		if !assert.NoError(t, err, `codegen.WriteFile should succeed`) {
+			return
+		}
+	}
+
+	// the marker only appears in a string literal, so this file is
+	// not considered to be generated
+	const literalSrc = "package foo\n\nconst s = `\n// Code generated by foo; DO NOT EDIT.\n`\n"
+	if !assert.NoError(t, codegen.WriteFile(literal, strings.NewReader(literalSrc), codegen.WithManifest(first)), `codegen.WriteFile should succeed`) {
+		return
+	}
+
+	if !assert.Equal(t, []string{bar, handwritten, edited, foo, literal}, first.Files(), `manifest should contain all files`) {
+		return
+	}
+	if !assert.NoError(t, first.Save(manifestFile), `manifest.Save should succeed`) {
+		return
+	}
+
+	// baz is taken over by a human
+	if !assert.NoError(t, ioutil.WriteFile(handwritten, []byte("package foo\n"), 0644), `ioutil.WriteFile should succeed`) {
+		return
+	}
+
+	// edited is modified by hand, but keeps the header
+	if !assert.NoError(t, ioutil.WriteFile(edited, []byte(codegen.GeneratedHeader("mygen", "")+"\n\npackage foo\n\n// important\n"), 0644), `ioutil.WriteFile should succeed`) {
+		return
+	}
+
+	// second run: only generates foo
+	prev, err := codegen.LoadManifest(manifestFile)
+	if !assert.NoError(t, err, `codegen.LoadManifest should succeed`) {
+		return
+	}
+
+	hash, ok := prev.Hash(foo)
+	if !assert.True(t, ok, `foo should be in the loaded manifest`) {
+		return
+	}
+	expectedHash, _ := first.Hash(foo)
+	if !assert.Equal(t, expectedHash, hash, `hash should survive a round trip`) {
+		return
+	}
+
+	current := codegen.NewManifest()
+	if !assert.NoError(t, codegen.WriteFile(foo, strings.NewReader("package foo\n"), codegen.WithGeneratedHeader("mygen"), codegen.WithManifest(current)), `codegen.WriteFile should succeed`) {
+		return
+	}
+
+	removed, err := codegen.Cleanup(prev, current)
+	if !assert.NoError(t, err, `codegen.Cleanup should succeed`) {
+		return
+	}
+	if !assert.Equal(t, []string{bar}, removed, `only bar should be removed`) {
+		return
+	}
+
+	for fn, exists := range map[string]bool{foo: true, bar: false, handwritten: true, edited: true, literal: true} {
+		_, err := os.Stat(fn)
+		if !assert.Equal(t, exists, err == nil, `existence of %s should match`, fn) {
+			return
+		}
+	}
+}
+
+func TestIsGenerated(t *testing.T) {
+	testcases := []struct {
+		Name     string
+		Source   string
+		Expected bool
+	}{
+		{Name: "Header", Source: "// Code generated by foo; DO NOT EDIT.\n\npackage x\n", Expected: true},
+		{Name: "AfterComments", Source: "// +build linux\n\n/* license */\n// Code generated by foo; DO NOT EDIT.\npackage x\n", Expected: true},
+		{Name: "CRLF", Source: "// Code generated by foo; DO NOT EDIT.\r\npackage x\r\n", Expected: true},
+		{Name: "None", Source: "package x\n", Expected: false},
+		{Name: "AfterPackage", Source: "package x\n\n// Code generated by foo; DO NOT EDIT.\n", Expected: false},
+		{Name: "StringLiteral", Source: "package x\n\nconst s = `\n// Code generated by foo; DO NOT EDIT.\n`\n", Expected: false},
+		{Name: "BlockComment", Source: "/*\n// Code generated by foo; DO NOT EDIT.\n*/\npackage x\n", Expected: false},
+	}
+	for _, tc := range testcases {
+		tc := tc
+		t.Run(tc.Name, func(t *testing.T) {
+			if !assert.Equal(t, tc.Expected, codegen.IsGenerated([]byte(tc.Source)), `codegen.IsGenerated should match`) {
+				return
+			}
+		})
+	}
+}
+
+func TestSession(t *testing.T) {
+	dir, err := ioutil.TempDir("", "codegen-test-")
+	if !assert.NoError(t, err, `ioutil.TempDir should succeed`) {
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	populate := func(s *codegen.Session, broken ...int) {
+		for i := 0; i < 10; i++ {
+			o := codegen.NewOutput(&bytes.Buffer{})
+			o.WritePackage("foo")
+			o.LL("func Foo%d() string {", i)
+			o.L("return %s(%d)", o.Qual("fmt", "Sprint"), i)
+			for _, b := range broken {
+				if b == i {
+					o.L("if {")
+				}
+			}
+			o.L("}")
+			s.Add(filepath.Join(dir, fmt.Sprintf("foo%d_gen.go", i)), o)
+		}
+	}
+
+	t.Run("Errors", func(t *testing.T) {
+		s := codegen.NewSession()
+		populate(s, 3, 7)
+
+		err := s.WriteFiles(codegen.WithFormatCode(true), codegen.WithConcurrency(3))
+		if !assert.Error(t, err, `s.WriteFiles should fail`) {
+			return
+		}
+
+		var serr codegen.SessionError
+		if !assert.True(t, errors.As(err, &serr), `error should be codegen.SessionError (got %T)`, err) {
+			return
+		}
+
+		ferrs := serr.Errors()
+		if !assert.Len(t, ferrs, 2, `there should be 2 errors`) {
+			return
+		}
+		for i, n := range []int{3, 7} {
+			if !assert.Equal(t, filepath.Join(dir, fmt.Sprintf("foo%d_gen.go", n)), ferrs[i].Filename, `filename should match`) {
+				return
+			}
+			var cfe codegen.CodeFormatError
+			if !assert.True(t, errors.As(ferrs[i], &cfe), `error should be codegen.CodeFormatError`) {
+				return
+			}
+		}
+
+		// all other files should have been written
+		entries, err := ioutil.ReadDir(dir)
+		if !assert.NoError(t, err, `ioutil.ReadDir should succeed`) {
+			return
+		}
+		if !assert.Len(t, entries, 8, `8 files should be written`) {
+			return
+		}
+	})
+	t.Run("Success", func(t *testing.T) {
+		s := codegen.NewSession()
+		populate(s)
+		s.AddReader(filepath.Join(dir, "reader_gen.go"), strings.NewReader("package foo\n"))
+		if !assert.NoError(t, s.WriteFiles(codegen.WithFormatCode(true)), `s.WriteFiles should succeed`) {
+			return
+		}
+
+		// the same session can be checked after it has been written
+		if !assert.NoError(t, s.CheckFiles(codegen.WithFormatCode(true)), `s.CheckFiles should succeed`) {
+			return
+		}
+		if !assert.NoError(t, os.Remove(filepath.Join(dir, "reader_gen.go")), `os.Remove should succeed`) {
+			return
+		}
+	})
+	t.Run("LocalPrefix", func(t *testing.T) {
+		fs := codegen.NewMemFS()
+		s := codegen.NewSession()
+		for i := 0; i < 10; i++ {
+			o := codegen.NewOutput(&bytes.Buffer{})
+			o.WritePackage("foo")
+			o.LL("var _ = %s", o.Qual("example.com/local/pkg", "X"))
+			o.L("var _ = %s // third-party", o.Qual("github.com/lestrrat-go/option", "New"))
+			o.L("var _ = %s", o.Qual("fmt", "Sprint"))
+			s.Add(fmt.Sprintf("foo%d_gen.go", i), o)
+		}
+
+		err := s.WriteFiles(
+			codegen.WithFormatCode(true),
+			codegen.WithFormatOnly(true),
+			codegen.WithLocalPrefix("example.com/local"),
+			codegen.WithConcurrency(4),
+			codegen.WithFS(fs),
+		)
+		if !assert.NoError(t, err, `s.WriteFiles should succeed`) {
+			return
+		}
+
+		const expected = `package foo
+
+import (
+	"fmt"
+
+	"github.com/lestrrat-go/option"
+
+	"example.com/local/pkg"
+)
+
+var _ = pkg.X
+var _ = option.New // third-party
+var _ = fmt.Sprint
+`
+		for i := 0; i < 10; i++ {
+			data, err := fs.ReadFile(fmt.Sprintf("foo%d_gen.go", i))
+			if !assert.NoError(t, err, `fs.ReadFile should succeed`) {
+				return
+			}
+			if !assert.Equal(t, expected, string(data), `output should match`) {
+				return
+			}
+		}
+	})
+	t.Run("Stale", func(t *testing.T) {
+		stale := []string{
+			filepath.Join(dir, "foo2_gen.go"),
+			filepath.Join(dir, "foo5_gen.go"),
+		}
+		for _, fn := range stale {
+			if !assert.NoError(t, ioutil.WriteFile(fn, []byte("package foo\n"), 0644), `ioutil.WriteFile should succeed`) {
+				return
+			}
+		}
+
+		s := codegen.NewSession()
+		populate(s)
+		err := s.CheckFiles(codegen.WithFormatCode(true))
+		var staleErr codegen.StaleError
+		if !assert.True(t, errors.As(err, &staleErr), `error should be codegen.StaleError (got %T)`, err) {
+			return
+		}
+		if !assert.Equal(t, stale, staleErr.Files(), `stale files should match`) {
+			return
+		}
+	})
+}
+
+func TestMemFS(t *testing.T) {
+	fs := codegen.NewMemFS()
+	manifest := codegen.NewManifest()
+
+	s := codegen.NewSession()
+	for _, name := range []string{"foo", "bar"} {
+		o := codegen.NewOutput(&bytes.Buffer{})
+		o.WritePackage(name)
+		s.Add(filepath.Join("gen", name, name+"_gen.go"), o)
+	}
+
+	err := s.WriteFiles(
+		codegen.WithFS(fs),
+		codegen.WithFormatCode(true),
+		codegen.WithGeneratedHeader("mygen"),
+		codegen.WithManifest(manifest),
+	)
+	if !assert.NoError(t, err, `s.WriteFiles should succeed`) {
+		return
+	}
+
+	expectedFiles := []string{
+		filepath.Join("gen", "bar", "bar_gen.go"),
+		filepath.Join("gen", "foo", "foo_gen.go"),
+	}
+	if !assert.Equal(t, expectedFiles, fs.Files(), `files should match`) {
+		return
+	}
+
+	data, err := fs.ReadFile(filepath.Join("gen", "foo", "foo_gen.go"))
+	if !assert.NoError(t, err, `fs.ReadFile should succeed`) {
+		return
+	}
+	if !assert.Equal(t, "// Code generated by mygen; DO NOT EDIT.\n\npackage foo\n", string(data), `file contents should match`) {
+		return
+	}
+
+	_, err = fs.ReadFile("missing.go")
+	if !assert.True(t, os.IsNotExist(err), `os.IsNotExist should be true (got %v)`, err) {
+		return
+	}
+
+	if !assert.NoError(t, manifest.Save("manifest.json", codegen.WithFS(fs)), `manifest.Save should succeed`) {
+		return
+	}
+
+	t.Run("Zip", func(t *testing.T) {
+		var buf bytes.Buffer
+		if !assert.NoError(t, fs.WriteZip(&buf), `fs.WriteZip should succeed`) {
+			return
+		}
+
+		zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+		if !assert.NoError(t, err, `zip.NewReader should succeed`) {
+			return
+		}
+
+		var names []string
+		for _, f := range zr.File {
+			names = append(names, f.Name)
+		}
+		if !assert.Equal(t, []string{"gen/bar/bar_gen.go", "gen/foo/foo_gen.go", "manifest.json"}, names, `archived files should match`) {
+			return
+		}
+	})
+	t.Run("Cleanup", func(t *testing.T) {
+		prev, err := codegen.LoadManifest("manifest.json", codegen.WithFS(fs))
+		if !assert.NoError(t, err, `codegen.LoadManifest should succeed`) {
+			return
+		}
+
+		current := codegen.NewManifest()
+		err = codegen.WriteFile(filepath.Join("gen", "foo", "foo_gen.go"), strings.NewReader("package foo\n"),
+			codegen.WithFS(fs),
+			codegen.WithFormatCode(true),
+			codegen.WithGeneratedHeader("mygen"),
+			codegen.WithManifest(current),
+		)
+		if !assert.NoError(t, err, `codegen.WriteFile should succeed`) {
+			return
+		}
+
+		removed, err := codegen.Cleanup(prev, current, codegen.WithFS(fs))
+		if !assert.NoError(t, err, `codegen.Cleanup should succeed`) {
+			return
+		}
+		if !assert.Equal(t, []string{filepath.Join("gen", "bar", "bar_gen.go")}, removed, `removed files should match`) {
+			return
+		}
+		if !assert.Equal(t, []string{filepath.Join("gen", "foo", "foo_gen.go"), "manifest.json"}, fs.Files(), `files should match`) {
+			return
+		}
+	})
+}
+
+func TestObject(t *testing.T) {
+	var _ codegen.Field = &codegen.ConstantField{}
+
+	testcases := []struct {
+		Name   string
+		Input  string
+		Verify func(*testing.T, *codegen.ConstantField)
+	}{
+		{
+			Name:  `Simple`,
+			Input: `{"name": "Field1"}`,
+			Verify: func(t *testing.T, f *codegen.ConstantField) {
+				if !assert.Equal(t, "Field1", f.Name(true), `name should match`) {
+					return
+				}
+
+				if !assert.Equal(t, "field1", f.Name(false), `unexported name should match`) {
+					return
+				}
+
+				if !assert.Equal(t, "string", f.Type(), `type should match`) {
+					return
+				}
+			},
+		},
+		{
+			Name:  `separate name, exported name, and unexported name`,
+			Input: `{"name": "Field1", "exported_name": "Foo", "unexported_name": "bar"}`,
+			Verify: func(t *testing.T, f *codegen.ConstantField) {
+				if !assert.Equal(t, "Foo", f.Name(true), `name should match`) {
+					return
+				}
+
+				if !assert.Equal(t, "bar", f.Name(false), `unexported name should match`) {
+					return
+				}
+
+				if !assert.Equal(t, "string", f.Type(), `type should match`) {
+					return
+				}
+			},
+		},
+	}
+	for _, tc := range testcases {
+		tc := tc
+		t.Run(tc.Name, func(t *testing.T) {
+			var field codegen.ConstantField
+			if !assert.NoError(t, json.Unmarshal([]byte(tc.Input), &field), `json.Unmarshal should succeed`) {
+				return
+			}
+			field.Organize()
+			tc.Verify(t, &field)
+		})
+	}
+}
+
+func TestObjectYAML(t *testing.T) {
+	const jsonSrc = `{
+  "name": "Person",
+  "comment": "Person represents a person",
+  "array_of": "PersonList",
+  "schema": {"version": 2, "tags": ["a", "b"]},
+  "fields": [
+    {"name": "first_name", "json": "first", "required": true, "max_length": 64},
+    {"name": "age", "type": "int", "skip_method": true},
+    {"name": "kind", "type": "string", "constant": "human", "weight": 1.5}
+  ]
+}`
+
+	const yamlSrc = `
+name: Person
+comment: Person represents a person
+array_of: PersonList
+schema:
+  version: 2
+  tags: [a, b]
+fields:
+  - name: first_name
+    json: first
+    required: true
+    max_length: 64
+  - name: age
+    type: int
+    skip_method: true
+  - name: kind
+    type: string
+    constant: human
+    weight: 1.5
+`
+
+	var fromJSON, fromYAML codegen.Object
+	if !assert.NoError(t, json.Unmarshal([]byte(jsonSrc), &fromJSON), `json.Unmarshal should succeed`) {
+		return
+	}
+	if !assert.NoError(t, yaml.Unmarshal([]byte(yamlSrc), &fromYAML), `yaml.Unmarshal should succeed`) {
+		return
+	}
+
+	if !assert.Equal(t, fromJSON, fromYAML, `objects decoded from JSON and YAML should match`) {
+		return
+	}
+
+	fields := fromYAML.Fields()
+	if !assert.Len(t, fields, 3, `there should be 3 fields`) {
+		return
+	}
+
+	c, ok := fields[2].(*codegen.ConstantField)
+	if !assert.True(t, ok, `third field should be a constant`) {
+		return
+	}
+	if !assert.Equal(t, "human", c.Value(), `constant value should match`) {
+		return
+	}
+
+	v, ok := fields[0].Extra("max_length")
+	if !assert.True(t, ok, `extra field should exist`) {
+		return
+	}
+	if !assert.Equal(t, float64(64), v, `numbers in extras should be decoded as float64`) {
+		return
+	}
+
+	t.Run("MergeKeys", func(t *testing.T) {
+		const src = `
+base: &base
+  type: int64
+  comment: from base
+  required: true
+fields:
+  - <<: *base
+    name: id
+    comment: overridden
+  - <<: [*base, {constant: 1}]
+    name: version
+`
+		var withMerge codegen.Object
+		if !assert.NoError(t, yaml.Unmarshal([]byte(src), &withMerge), `yaml.Unmarshal should succeed`) {
+			return
+		}
+
+		const expandedSrc = `{
+  "base": {"type": "int64", "comment": "from base", "required": true},
+  "fields": [
+    {"type": "int64", "comment": "overridden", "required": true, "name": "id"},
+    {"type": "int64", "comment": "from base", "required": true, "constant": 1, "name": "version"}
+  ]
+}`
+		var expanded codegen.Object
+		if !assert.NoError(t, json.Unmarshal([]byte(expandedSrc), &expanded), `json.Unmarshal should succeed`) {
+			return
+		}
+		if !assert.Equal(t, expanded, withMerge, `merge keys should be expanded`) {
+			return
+		}
+	})
+	t.Run("ScalarTypes", func(t *testing.T) {
+		for _, src := range []string{
+			`name: [foo]`,
+			`fields: [{name: foo, type: 123}]`,
+			`fields: [{name: foo, required: yes}]`,
+			`fields: [{name: foo, json: true}]`,
+		} {
+			var o codegen.Object
+			if !assert.Error(t, yaml.Unmarshal([]byte(src), &o), `yaml.Unmarshal should fail for %q`, src) {
+				return
+			}
+		}
+
+		var o codegen.Object
+		if !assert.NoError(t, yaml.Unmarshal([]byte(`{name: "123", comment: ~}`), &o), `yaml.Unmarshal should succeed`) {
+			return
+		}
+		if !assert.Equal(t, "123", o.Name(false), `quoted numbers are strings`) {
+			return
+		}
+	})
+	t.Run("Invalid", func(t *testing.T) {
+		var o codegen.Object
+		if !assert.Error(t, yaml.Unmarshal([]byte(`fields: {name: foo}`), &o), `yaml.Unmarshal should fail`) {
+			return
+		}
+		if !assert.Error(t, yaml.Unmarshal([]byte(`- name: foo`), &o), `yaml.Unmarshal should fail`) {
+			return
+		}
+	})
+}
+
+func TestDecodeJSONC(t *testing.T) {
+	const src = `{
+  // the name of the object
+  "name": "Person",
+  /*
+   * fields are sorted by Organize(), so the order here
+   * does not matter
+   */
+  "fields": [
+    {"name": "first_name", "comment": "// not a comment",},
+    {"name": "kind", "constant": "human"}, // trailing comma
+  ],
+}`
+
+	var o codegen.Object
+	if !assert.NoError(t, codegen.DecodeJSONC([]byte(src), &o), `codegen.DecodeJSONC should succeed`) {
+		return
+	}
+
+	if !assert.Equal(t, "Person", o.Name(true), `name should match`) {
+		return
+	}
+
+	fields := o.Fields()
+	if !assert.Len(t, fields, 2, `there should be 2 fields`) {
+		return
+	}
+	if !assert.Equal(t, "// not a comment", fields[0].Comment(), `comment markers in strings should be preserved`) {
+		return
+	}
+	if !assert.IsType(t, &codegen.ConstantField{}, fields[1], `second field should be a constant`) {
+		return
+	}
+
+	t.Run("Offsets", func(t *testing.T) {
+		const src = "[1, /* two */ 2,\n// three\n3,]"
+		got, err := codegen.StandardizeJSONC([]byte(src))
+		if !assert.NoError(t, err, `codegen.StandardizeJSONC should succeed`) {
+			return
+		}
+		if !assert.Equal(t, "[1,           2,\n        \n3 ]", string(got), `removed bytes should be replaced with spaces`) {
+			return
+		}
+	})
+
+	t.Run("SyntaxError", func(t *testing.T) {
+		const src = "{\n  // comment\n  \"name\": \"Person\"\n  \"comment\": \"missing comma\"\n}"
+		var o codegen.Object
+		err := codegen.DecodeJSONC([]byte(src), &o)
+		if !assert.Error(t, err, `codegen.DecodeJSONC should fail`) {
+			return
+		}
+		if !assert.Contains(t, err.Error(), `line 4, column 3`, `error should contain the position`) {
+			return
+		}
+	})
+
+	t.Run("UnterminatedComment", func(t *testing.T) {
+		var o codegen.Object
+		err := codegen.DecodeJSONC([]byte("{\n  /* name"), &o)
+		if !assert.Error(t, err, `codegen.DecodeJSONC should fail`) {
+			return
+		}
+		if !assert.Contains(t, err.Error(), `line 2, column 3`, `error should contain the position`) {
+			return
+		}
+	})
+}
+
+func TestDecodeError(t *testing.T) {
+	const src = `{
+  "objects": [
+    {"name": "A"},
+    {"name": "B"},
+    {
+      "name": "C",
+      "fields": [
+        {"name": "a"},
+        {"name": "b"},
+        {"name": "c"},
+        {"name": "d", "type": 42}
+      ]
+    }
+  ]
+}`
+
+	var spec struct {
+		Objects codegen.ObjectList `json:"objects"`
+	}
+
+	check := func(t *testing.T, err error, path string, document bool, line, column int) {
+		var de codegen.DecodeError
+		if !assert.True(t, errors.As(err, &de), `error should be a DecodeError`) {
+			return
+		}
+		if !assert.Equal(t, path, de.Path(), `path should match`) {
+			return
+		}
+		if !assert.Equal(t, document, de.IsDocumentPosition(), `IsDocumentPosition should match`) {
+			return
+		}
+		if !assert.Equal(t, line, de.Line(), `line should match`) {
+			return
+		}
+		if !assert.Equal(t, column, de.Column(), `column should match`) {
+			return
+		}
+
+		var te *json.UnmarshalTypeError
+		if !assert.True(t, errors.As(err, &te), `underlying error should be available`) {
+			return
+		}
+	}
+
+	t.Run("json.Unmarshal", func(t *testing.T) {
+		err := json.Unmarshal([]byte(src), &spec)
+		if !assert.Error(t, err, `json.Unmarshal should fail`) {
+			return
+		}
+		// the path and position are relative to the object list,
+		// which starts on line 2
+		check(t, err, "[2].fields[3].type", false, 10, 31)
+		if !assert.Equal(t, `[2].fields[3].type: json: cannot unmarshal number into Go value of type string`, err.Error(), `relative positions should not be in the message`) {
+			return
+		}
+	})
+	t.Run("DecodeJSONC", func(t *testing.T) {
+		err := codegen.DecodeJSONC([]byte(src), &spec)
+		if !assert.Error(t, err, `codegen.DecodeJSONC should fail`) {
+			return
+		}
+		check(t, err, "objects[2].fields[3].type", true, 11, 31)
+		if !assert.Contains(t, err.Error(), `objects[2].fields[3].type (line 11, column 31)`, `error message should contain the path and position`) {
+			return
+		}
+	})
+	t.Run("DuplicateValues", func(t *testing.T) {
+		// the same value appears twice, but only the second one fails
+		const src = `{
+  "a": {"fields": [{"name": "x", "type": 1}]},
+  "b": {"fields": [{"name": "x", "type": 1}]}
+}`
+		var v struct {
+			A json.RawMessage `json:"a"`
+			B codegen.Object  `json:"b"`
+		}
+		err := codegen.DecodeJSONC([]byte(src), &v)
+		if !assert.Error(t, err, `codegen.DecodeJSONC should fail`) {
+			return
+		}
+		check(t, err, "b.fields[0].type", true, 3, 42)
+	})
+}
+
+func TestStrict(t *testing.T) {
+	const src = `[
+  {
+    "name": "Person",
+    "schema": "person.json",
+    "fields": [
+      {"name": "first_name", "max_length": 64},
+      {"name": "age", "type": "int", "requried": true}
+    ]
+  }
+]`
+
+	t.Run("NotStrict", func(t *testing.T) {
+		var list codegen.ObjectList
+		if !assert.NoError(t, codegen.DecodeJSONC([]byte(src), &list), `codegen.DecodeJSONC should succeed`) {
+			return
+		}
+		v, ok := list[0].Fields()[1].Extra("requried")
+		if !assert.True(t, ok, `unknown key should be stored as an extra`) {
+			return
+		}
+		if !assert.Equal(t, true, v, `extra should match`) {
+			return
+		}
+	})
+	t.Run("UnknownKey", func(t *testing.T) {
+		var list codegen.ObjectList
+		err := codegen.DecodeJSONC([]byte(src), &list,
+			codegen.WithStrict(true),
+			codegen.WithAllowedObjectKeys("schema"),
+			codegen.WithAllowedFieldKeys("max_length"),
+		)
+		if !assert.Error(t, err, `codegen.DecodeJSONC should fail`) {
+			return
+		}
+
+		var uke codegen.UnknownKeyError
+		if !assert.True(t, errors.As(err, &uke), `error should be an UnknownKeyError`) {
+			return
+		}
+		if !assert.Equal(t, "requried", uke.Key(), `key should match`) {
+			return
+		}
+		if !assert.Equal(t, "required", uke.Suggestion(), `suggestion should match`) {
+			return
+		}
+
+		var de codegen.DecodeError
+		if !assert.True(t, errors.As(err, &de), `error should be a DecodeError`) {
+			return
+		}
+		if !assert.Equal(t, "[0].fields[1].requried", de.Path(), `path should match`) {
+			return
+		}
+		if !assert.Equal(t, 7, de.Line(), `line should match`) {
+			return
+		}
+		if !assert.Contains(t, err.Error(), `unknown key "requried" (did you mean "required"?)`, `error message should contain the suggestion`) {
+			return
+		}
+	})
+	t.Run("NotAllowed", func(t *testing.T) {
+		var list codegen.ObjectList
+		err := codegen.DecodeJSONC([]byte(src), &list,
+			codegen.WithStrict(true),
+			codegen.WithAllowedFieldKeys("max_length", "requried"),
+		)
+		if !assert.Error(t, err, `codegen.DecodeJSONC should fail`) {
+			return
+		}
+
+		var uke codegen.UnknownKeyError
+		if !assert.True(t, errors.As(err, &uke), `error should be an UnknownKeyError`) {
+			return
+		}
+		if !assert.Equal(t, "schema", uke.Key(), `key should match`) {
+			return
+		}
+		if !assert.Equal(t, "", uke.Suggestion(), `there should be no suggestion`) {
+			return
+		}
+	})
+	t.Run("Allowed", func(t *testing.T) {
+		var list codegen.ObjectList
+		err := codegen.DecodeJSONC([]byte(src), &list,
+			codegen.WithStrict(true),
+			codegen.WithAllowedObjectKeys("schema"),
+			codegen.WithAllowedFieldKeys("max_length", "requried"),
+		)
+		if !assert.NoError(t, err, `codegen.DecodeJSONC should succeed`) {
+			return
+		}
+		if !assert.Equal(t, "person.json", list[0].String("schema"), `allowed extras should be stored`) {
+			return
+		}
+	})
+	t.Run("YAML", func(t *testing.T) {
+		const src = `
+- name: Person
+  schema: person.json
+  fields:
+    - name: first_name
+      max_length: 64
+    - <<: {type: int}
+      name: age
+      requried: true
+    - name: kind
+      constant: human
+      weigth: 1
+`
+		options := []codegen.Option{
+			codegen.WithStrict(true),
+			codegen.WithAllowedObjectKeys("schema"),
+			codegen.WithAllowedFieldKeys("max_length", "weight"),
+		}
+
+		var list codegen.ObjectList
+		err := codegen.DecodeYAML([]byte(src), &list, options...)
+		if !assert.Error(t, err, `codegen.DecodeYAML should fail`) {
+			return
+		}
+
+		var uke codegen.UnknownKeyError
+		if !assert.True(t, errors.As(err, &uke), `error should be an UnknownKeyError`) {
+			return
+		}
+		if !assert.Equal(t, "required", uke.Suggestion(), `suggestion should match`) {
+			return
+		}
+		if !assert.Contains(t, err.Error(), `line 9: unknown key "requried" (did you mean "required"?)`, `error message should contain the line`) {
+			return
+		}
+
+		// constant fields are checked too
+		err = codegen.DecodeYAML([]byte(strings.Replace(src, "requried", "required", 1)), &list, options...)
+		if !assert.True(t, errors.As(err, &uke), `error should be an UnknownKeyError`) {
+			return
+		}
+		if !assert.Equal(t, "weigth", uke.Key(), `key should match`) {
+			return
+		}
+		if !assert.Equal(t, "weight", uke.Suggestion(), `suggestion should match`) {
+			return
+		}
+
+		// without strict mode, unknown keys are stored as extras
+		if !assert.NoError(t, codegen.DecodeYAML([]byte(src), &list), `codegen.DecodeYAML should succeed`) {
+			return
+		}
+		if !assert.True(t, list[0].Fields()[1].Bool("requried"), `unknown key should be stored as an extra`) {
+			return
+		}
+	})
+	t.Run("Nested", func(t *testing.T) {
+		type spec struct {
+			Package string                       `json:"package" yaml:"package"`
+			Objects []codegen.Object             `json:"objects" yaml:"objects"`
+			Extra   map[string]codegen.FieldList `json:"extra" yaml:"extra"`
+		}
+
+		t.Run("JSON", func(t *testing.T) {
+			src := `{"package": "people", "objects": ` + src + `}`
+			var v spec
+			err := codegen.DecodeJSON([]byte(src), &v,
+				codegen.WithStrict(true),
+				codegen.WithAllowedObjectKeys("schema"),
+				codegen.WithAllowedFieldKeys("max_length"),
+			)
+			var de codegen.DecodeError
+			if !assert.True(t, errors.As(err, &de), `error should be a DecodeError (got %v)`, err) {
+				return
+			}
+			if !assert.Equal(t, "objects[0].fields[1].requried", de.Path(), `path should match`) {
+				return
+			}
+			if !assert.Equal(t, 7, de.Line(), `line should match`) {
+				return
+			}
+
+			src = `{"extra": {"list": [{"name": "x", "tpye": "int"}]}}`
+			err = codegen.DecodeJSON([]byte(src), &v, codegen.WithStrict(true))
+			var uke codegen.UnknownKeyError
+			if !assert.True(t, errors.As(err, &uke), `error should be an UnknownKeyError (got %v)`, err) {
+				return
+			}
+			if !assert.Equal(t, "type", uke.Suggestion(), `suggestion should match`) {
+				return
+			}
+
+			// strictness does not leak into later decoding
+			if !assert.NoError(t, json.Unmarshal([]byte(src), &v), `json.Unmarshal should succeed`) {
+				return
+			}
+		})
+		t.Run("YAML", func(t *testing.T) {
+			const src = `
+package: people
+objects:
+  - name: Person
+    fields:
+      - name: age
+        requried: true
+`
+			var v spec
+			err := codegen.DecodeYAML([]byte(src), &v, codegen.WithStrict(true))
+			var uke codegen.UnknownKeyError
+			if !assert.True(t, errors.As(err, &uke), `error should be an UnknownKeyError (got %v)`, err) {
+				return
+			}
+			if !assert.Contains(t, err.Error(), `line 7`, `error message should contain the line`) {
+				return
+			}
+		})
+	})
+	t.Run("DecodeJSON", func(t *testing.T) {
+		var list codegen.ObjectList
+		if !assert.NoError(t, codegen.DecodeJSON([]byte(src), &list), `codegen.DecodeJSON should succeed`) {
+			return
+		}
+		if !assert.Error(t, codegen.DecodeJSON([]byte("// comment\n"+src), &list), `codegen.DecodeJSON should reject comments`) {
+			return
+		}
+	})
+}
+
+func TestObjectMarshal(t *testing.T) {
+	const src = `{
+  "zzz": {"b": 1, "a": [true, null]},
+  "fields": [
+    {"max_length": 64, "required": true, "name": "first_name", "json": "first", "type": "map[string]<-chan int"},
+    {"name": "kind", "constant": null, "weight": 1.5},
+    {"name": "age", "type": "int", "skip_method": true, "getter": "GetAge", "comment": "age in years"}
+  ],
+  "array_of": "PersonList",
+  "name": "Person",
+  "exported_name": "Person",
+  "aaa": "extra"
+}`
+
+	var o codegen.Object
+	if !assert.NoError(t, json.Unmarshal([]byte(src), &o), `json.Unmarshal should succeed`) {
+		return
+	}
+
+	t.Run("JSON", func(t *testing.T) {
+		var buf bytes.Buffer
+		enc := json.NewEncoder(&buf)
+		enc.SetEscapeHTML(false)
+		if !assert.NoError(t, enc.Encode(&o), `Encode should succeed`) {
+			return
+		}
+
+		const expected = `{"name":"Person","exported_name":"Person","array_of":"PersonList",` +
+			`"fields":[` +
+			`{"name":"first_name","type":"map[string]<-chan int","json":"first","required":true,"max_length":64},` +
+			`{"name":"kind","constant":null,"weight":1.5},` +
+			`{"name":"age","comment":"age in years","type":"int","getter":"GetAge","skip_method":true}` +
+			`],"aaa":"extra","zzz":{"a":[true,null],"b":1}}` + "\n"
+		if !assert.Equal(t, expected, buf.String(), `output should match`) {
+			return
+		}
+
+		var decoded codegen.Object
+		if !assert.NoError(t, json.Unmarshal(buf.Bytes(), &decoded), `json.Unmarshal should succeed`) {
+			return
+		}
+		if !assert.Equal(t, o, decoded, `decoded object should match the original`) {
+			return
+		}
+	})
+	t.Run("DerivedValues", func(t *testing.T) {
+		const src = `{"name":"foo_bar","fields":[{"name":"baz"}]}`
+
+		var o codegen.Object
+		if !assert.NoError(t, json.Unmarshal([]byte(src), &o), `json.Unmarshal should succeed`) {
+			return
+		}
+
+		// names and types derived by Organize and Name are not part of the spec
+		o.Organize()
+		if !assert.Equal(t, "FooBar", o.Name(true), `exported name should match`) {
+			return
+		}
+		if !assert.Equal(t, "fooBar", o.Name(false), `unexported name should match`) {
+			return
+		}
+		if !assert.Equal(t, "string", o.Fields()[0].Type(), `type should default to string`) {
+			return
+		}
+		if !assert.Equal(t, "Baz", o.Fields()[0].Name(true), `field name should match`) {
+			return
+		}
+
+		buf, err := json.Marshal(&o)
+		if !assert.NoError(t, err, `json.Marshal should succeed`) {
+			return
+		}
+		if !assert.Equal(t, src, string(buf), `only specified values should be encoded`) {
+			return
+		}
+
+		buf, err = yaml.Marshal(&o)
+		if !assert.NoError(t, err, `yaml.Marshal should succeed`) {
+			return
+		}
+		if !assert.Equal(t, "name: foo_bar\nfields:\n    - name: baz\n", string(buf), `only specified values should be encoded`) {
+			return
+		}
+	})
+	t.Run("YAML", func(t *testing.T) {
+		buf, err := yaml.Marshal(&o)
+		if !assert.NoError(t, err, `yaml.Marshal should succeed`) {
+			return
+		}
+
+		var decoded codegen.Object
+		if !assert.NoError(t, yaml.Unmarshal(buf, &decoded), `yaml.Unmarshal should succeed`) {
+			return
+		}
+		if !assert.Equal(t, o, decoded, `decoded object should match the original`) {
+			return
+		}
+		if !assert.True(t, strings.HasPrefix(string(buf), "name: Person\nexported_name: Person\narray_of: PersonList\nfields:\n"), `known attributes should be encoded first`) {
+			t.Logf("%s", buf)
+			return
+		}
+	})
+	t.Run("Value", func(t *testing.T) {
+		// objects that are not addressable are encoded the same way
+		v := struct {
+			Objects []codegen.Object          `json:"objects" yaml:"objects"`
+			ByName  map[string]codegen.Object `json:"by_name" yaml:"by_name"`
+		}{
+			Objects: []codegen.Object{o},
+			ByName:  map[string]codegen.Object{"person": o},
+		}
+
+		expected, err := json.Marshal(&o)
+		if !assert.NoError(t, err, `json.Marshal should succeed`) {
+			return
+		}
+		buf, err := json.Marshal(o)
+		if !assert.NoError(t, err, `json.Marshal should succeed`) {
+			return
+		}
+		if !assert.Equal(t, string(expected), string(buf), `value should be encoded like a pointer`) {
+			return
+		}
+
+		buf, err = json.Marshal(v)
+		if !assert.NoError(t, err, `json.Marshal should succeed`) {
+			return
+		}
+		if !assert.Equal(t, `{"objects":[`+string(expected)+`],"by_name":{"person":`+string(expected)+`}}`, string(buf), `nested values should be encoded`) {
+			return
+		}
+
+		expected, err = yaml.Marshal(&o)
+		if !assert.NoError(t, err, `yaml.Marshal should succeed`) {
+			return
+		}
+		buf, err = yaml.Marshal(o)
+		if !assert.NoError(t, err, `yaml.Marshal should succeed`) {
+			return
+		}
+		if !assert.Equal(t, string(expected), string(buf), `value should be encoded like a pointer`) {
+			return
+		}
+	})
 }
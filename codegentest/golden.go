@@ -0,0 +1,104 @@
+// Package codegentest provides helpers for testing code generators
+// built using github.com/lestrrat-go/codegen.
+package codegentest
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/lestrrat-go/codegen"
+	"github.com/lestrrat-go/codegen/internal/diff"
+)
+
+// UpdateEnv is the name of the environment variable that, when set to
+// a non-empty value, causes golden files to be updated instead of compared
+const UpdateEnv = "CODEGEN_UPDATE_GOLDEN"
+
+// GoldenDir is the directory golden files are stored in, relative to
+// the directory of the package being tested
+const GoldenDir = "testdata"
+
+var update = flag.Bool("codegentest.update", false, "update golden files instead of comparing against them")
+
+func updateGolden() bool {
+	return *update || os.Getenv(UpdateEnv) != ""
+}
+
+// GoldenPath returns the path of the golden file `name`
+func GoldenPath(name string) string {
+	return filepath.Join(GoldenDir, name+".golden")
+}
+
+// Generate writes the code in `o` to a buffer using codegen.Write,
+// and returns the result. The test is aborted if the code cannot be
+// generated. Code that fails to format or type check is reported
+// along with the lines around the errors.
+func Generate(t testing.TB, o *codegen.Output, options ...codegen.Option) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	if err := o.Write(&buf, options...); err != nil {
+		var cfe codegen.CodeFormatError
+		var tce codegen.TypeCheckError
+		switch {
+		case errors.As(err, &cfe):
+			t.Fatalf("failed to generate code: %s\n%s", err, cfe.Snippet(3))
+		case errors.As(err, &tce):
+			t.Fatalf("failed to generate code: %s\n%s", err, tce.Snippet(3))
+		default:
+			t.Fatalf("failed to generate code: %s", err)
+		}
+	}
+	return buf.Bytes()
+}
+
+// AssertGolden compares `got` against the contents of the golden file
+// testdata/<name>.golden, and reports a unified diff if they differ.
+//
+// If the test binary is run with -codegentest.update, or with the
+// CODEGEN_UPDATE_GOLDEN environment variable set, the golden file is
+// overwritten with `got` instead.
+//
+// Returns true if the contents match (or the golden file was updated)
+func AssertGolden(t testing.TB, name string, got []byte) bool {
+	t.Helper()
+
+	path := GoldenPath(name)
+	if updateGolden() {
+		var fs codegen.OSFS
+		if err := fs.WriteFile(path, got); err != nil {
+			t.Errorf("failed to update golden file %s: %s", path, err)
+			return false
+		}
+		return true
+	}
+
+	expected, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			t.Errorf("golden file %s does not exist (run with -codegentest.update or %s=1 to create it)", path, UpdateEnv)
+		} else {
+			t.Errorf("failed to read golden file %s: %s", path, err)
+		}
+		return false
+	}
+
+	if !bytes.Equal(expected, got) {
+		t.Errorf("generated code does not match golden file %s (run with -codegentest.update or %s=1 to update it):\n%s",
+			path, UpdateEnv, diff.Unified(path, "generated", expected, got))
+		return false
+	}
+	return true
+}
+
+// AssertOutput generates the code in `o` as Generate does, and compares
+// the result against the golden file `name` as AssertGolden does
+func AssertOutput(t testing.TB, name string, o *codegen.Output, options ...codegen.Option) bool {
+	t.Helper()
+	return AssertGolden(t, name, Generate(t, o, options...))
+}
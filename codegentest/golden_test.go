@@ -0,0 +1,105 @@
+package codegentest_test
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/lestrrat-go/codegen"
+	"github.com/lestrrat-go/codegen/codegentest"
+	"github.com/stretchr/testify/assert"
+)
+
+// recorder captures failures reported through testing.TB, so that
+// the failure cases can be tested
+type recorder struct {
+	testing.TB
+	errors []string
+}
+
+func (r *recorder) Helper() {}
+
+func (r *recorder) Errorf(format string, args ...interface{}) {
+	r.errors = append(r.errors, fmt.Sprintf(format, args...))
+}
+
+func hello(greeting string) *codegen.Output {
+	o := codegen.NewOutput(&bytes.Buffer{})
+	o.WritePackage("main")
+	o.LL("func main() {")
+	o.L("%s(%q)", o.Qual("fmt", "Println"), greeting)
+	o.L("}")
+	return o
+}
+
+var options = []codegen.Option{
+	codegen.WithFormatCode(true),
+	codegen.WithGeneratedHeader("codegentest"),
+}
+
+func TestAssertGolden(t *testing.T) {
+	t.Run("Match", func(t *testing.T) {
+		codegentest.AssertOutput(t, "hello", hello("Hello, World!"), options...)
+	})
+	t.Run("Mismatch", func(t *testing.T) {
+		r := &recorder{TB: t}
+		if !assert.False(t, codegentest.AssertOutput(r, "hello", hello("Goodbye, World!"), options...), `codegentest.AssertOutput should fail`) {
+			return
+		}
+
+		if !assert.Len(t, r.errors, 1, `there should be 1 error`) {
+			return
+		}
+		if !assert.Contains(t, r.errors[0], "-\tfmt.Println(\"Hello, World!\")\n+\tfmt.Println(\"Goodbye, World!\")\n", `error should contain a diff`) {
+			return
+		}
+	})
+	t.Run("Missing", func(t *testing.T) {
+		r := &recorder{TB: t}
+		if !assert.False(t, codegentest.AssertGolden(r, "missing", []byte("package main\n")), `codegentest.AssertGolden should fail`) {
+			return
+		}
+		if !assert.Len(t, r.errors, 1, `there should be 1 error`) {
+			return
+		}
+		if !assert.True(t, strings.Contains(r.errors[0], "does not exist"), `error should mention the missing file`) {
+			return
+		}
+	})
+	t.Run("Update", func(t *testing.T) {
+		dir, err := ioutil.TempDir("", "codegentest-")
+		if !assert.NoError(t, err, `ioutil.TempDir should succeed`) {
+			return
+		}
+		defer os.RemoveAll(dir)
+
+		wd, err := os.Getwd()
+		if !assert.NoError(t, err, `os.Getwd should succeed`) {
+			return
+		}
+		if !assert.NoError(t, os.Chdir(dir), `os.Chdir should succeed`) {
+			return
+		}
+		defer os.Chdir(wd)
+
+		os.Setenv(codegentest.UpdateEnv, "1")
+		defer os.Unsetenv(codegentest.UpdateEnv)
+
+		got := []byte("package main\n")
+		if !assert.True(t, codegentest.AssertGolden(t, "updated", got), `codegentest.AssertGolden should succeed`) {
+			return
+		}
+
+		written, err := ioutil.ReadFile(filepath.Join(dir, "testdata", "updated.golden"))
+		if !assert.NoError(t, err, `ioutil.ReadFile should succeed`) {
+			return
+		}
+		if !assert.Equal(t, got, written, `golden file should be updated`) {
+			return
+		}
+	})
+}
@@ -0,0 +1,202 @@
+package codegentest
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// DefaultModulePath is the module path used when Module.Path is empty
+const DefaultModulePath = "codegentest.example/generated"
+
+// Module describes a temporary Go module that generated code is compiled
+// and tested in. It is laid out in a temporary directory, and the go
+// command is run with the module proxy and checksum database disabled,
+// so that only the standard library and modules made available through
+// Replace can be used. This allows the tests to run offline.
+type Module struct {
+	// Path is the module path. Defaults to DefaultModulePath
+	Path string
+	// GoVersion is the version used in the go directive of go.mod.
+	// Defaults to the version of the running toolchain
+	GoVersion string
+	// Files maps the path of each file relative to the module root
+	// to its contents, e.g. "foo_gen.go" or "sub/foo_test.go"
+	Files map[string][]byte
+	// Replace maps module paths to local directories that provide them.
+	// Each module is required, and replaced by the directory
+	Replace map[string]string
+	// SourceDir is the directory that the generated files are written to
+	// outside of the tests. If specified, file names in the output of the
+	// go command are rewritten to refer to this directory, so that errors
+	// can be traced back to where the files are generated
+	SourceDir string
+}
+
+// AddFile adds a file to the module. `name` is relative to the module root
+func (m *Module) AddFile(name string, data []byte) {
+	if m.Files == nil {
+		m.Files = make(map[string][]byte)
+	}
+	m.Files[filepath.ToSlash(name)] = data
+}
+
+// Go lays out the module in a temporary directory, and runs the go
+// command with the given arguments in it. The combined output is returned
+// with the names of the files in the temporary directory mapped back to
+// their names in the module (or in SourceDir, if specified).
+// The temporary directory is removed before returning
+func (m *Module) Go(args ...string) (string, error) {
+	dir, err := ioutil.TempDir("", "codegentest-")
+	if err != nil {
+		return "", fmt.Errorf(`failed to create temporary directory: %w`, err)
+	}
+	defer os.RemoveAll(dir)
+
+	// the go command reports paths with symbolic links resolved
+	if resolved, err := filepath.EvalSymlinks(dir); err == nil {
+		dir = resolved
+	}
+
+	if err := m.layout(dir); err != nil {
+		return "", err
+	}
+
+	cmd := exec.Command("go", args...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(),
+		"GOFLAGS=-mod=mod",
+		"GOPROXY=off",
+		"GOSUMDB=off",
+		"GOWORK=off",
+	)
+
+	out, err := cmd.CombinedOutput()
+	output := m.mapOutput(dir, string(out))
+	if err != nil {
+		return output, fmt.Errorf(`go %s failed: %w`, strings.Join(args, " "), err)
+	}
+	return output, nil
+}
+
+func (m *Module) layout(dir string) error {
+	var gomod bytes.Buffer
+	modpath := m.Path
+	if modpath == "" {
+		modpath = DefaultModulePath
+	}
+	goVersion := m.GoVersion
+	if goVersion == "" {
+		goVersion = toolchainVersion()
+	}
+	fmt.Fprintf(&gomod, "module %s\n\ngo %s\n", modpath, goVersion)
+
+	if len(m.Replace) > 0 {
+		paths := make([]string, 0, len(m.Replace))
+		for path := range m.Replace {
+			paths = append(paths, path)
+		}
+		sort.Strings(paths)
+
+		gomod.WriteString("\nrequire (\n")
+		for _, path := range paths {
+			fmt.Fprintf(&gomod, "\t%s v0.0.0\n", path)
+		}
+		gomod.WriteString(")\n\nreplace (\n")
+		for _, path := range paths {
+			local, err := filepath.Abs(m.Replace[path])
+			if err != nil {
+				return fmt.Errorf(`failed to resolve directory for %s: %w`, path, err)
+			}
+			fmt.Fprintf(&gomod, "\t%s => %s\n", path, local)
+		}
+		gomod.WriteString(")\n")
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(dir, "go.mod"), gomod.Bytes(), 0644); err != nil {
+		return fmt.Errorf(`failed to write go.mod: %w`, err)
+	}
+
+	for name, data := range m.Files {
+		fn := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(fn), 0755); err != nil {
+			return fmt.Errorf(`failed to create directory for %s: %w`, name, err)
+		}
+		if err := ioutil.WriteFile(fn, data, 0644); err != nil {
+			return fmt.Errorf(`failed to write %s: %w`, name, err)
+		}
+	}
+	return nil
+}
+
+var rxToolchainVersion = regexp.MustCompile(`^go(\d+\.\d+)`)
+
+// toolchainVersion returns the major and minor version of the running
+// toolchain, for use in the go directive
+func toolchainVersion() string {
+	if m := rxToolchainVersion.FindStringSubmatch(runtime.Version()); m != nil {
+		return m[1]
+	}
+	return "1.16"
+}
+
+// mapOutput rewrites the names of files in the temporary directory `dir`
+// found in the output of the go command
+func (m *Module) mapOutput(dir, output string) string {
+	prefix := ""
+	if m.SourceDir != "" {
+		prefix = filepath.ToSlash(filepath.Clean(m.SourceDir)) + "/"
+	}
+
+	output = strings.ReplaceAll(output, dir+string(filepath.Separator), prefix)
+
+	lines := strings.Split(output, "\n")
+	for i, line := range lines {
+		// the go command reports files in the current directory as "./foo.go"
+		if strings.HasPrefix(line, "./") {
+			line = prefix + strings.TrimPrefix(line, "./")
+		} else if prefix != "" {
+			for name := range m.Files {
+				if strings.HasPrefix(line, name+":") {
+					line = prefix + line
+					break
+				}
+			}
+		}
+		lines[i] = line
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Build runs `go build ./...` in the module, and reports the output of
+// the compiler as a test error if it fails. Returns true on success
+func Build(t testing.TB, m *Module) bool {
+	t.Helper()
+	return run(t, m, "build", "./...")
+}
+
+// Test runs `go test ./...` in the module with the additional arguments,
+// and reports the output as a test error if it fails. Returns true on
+// success
+func Test(t testing.TB, m *Module, args ...string) bool {
+	t.Helper()
+	return run(t, m, append([]string{"test"}, append(args, "./...")...)...)
+}
+
+func run(t testing.TB, m *Module, args ...string) bool {
+	t.Helper()
+	output, err := m.Go(args...)
+	if err != nil {
+		t.Errorf("%s\n%s", err, output)
+		return false
+	}
+	return true
+}
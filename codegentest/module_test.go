@@ -0,0 +1,63 @@
+package codegentest_test
+
+import (
+	"testing"
+
+	"github.com/lestrrat-go/codegen/codegentest"
+	"github.com/stretchr/testify/assert"
+)
+
+const greeterTest = `package greeter
+
+import "testing"
+
+func TestGreet(t *testing.T) {
+	if got := Greet("World"); got != "Hello, World!" {
+		t.Errorf("unexpected greeting: %q", got)
+	}
+}
+`
+
+func TestModule(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping test that runs the go command in short mode")
+	}
+
+	t.Run("Success", func(t *testing.T) {
+		var m codegentest.Module
+		m.AddFile("greeter_gen.go", []byte("package greeter\n\nimport \"fmt\"\n\nfunc Greet(s string) string {\n\treturn fmt.Sprintf(\"Hello, %s!\", s)\n}\n"))
+		m.AddFile("greeter_test.go", []byte(greeterTest))
+
+		if !codegentest.Build(t, &m) {
+			return
+		}
+		if !codegentest.Test(t, &m, "-count=1") {
+			return
+		}
+	})
+	t.Run("CompileError", func(t *testing.T) {
+		m := codegentest.Module{SourceDir: "gen/greeter"}
+		m.AddFile("greeter_gen.go", []byte("package greeter\n\nfunc Greet(s string) string {\n\treturn undefined\n}\n"))
+
+		output, err := m.Go("build", "./...")
+		if !assert.Error(t, err, `m.Go should fail`) {
+			return
+		}
+		if !assert.Contains(t, output, "gen/greeter/greeter_gen.go:4:9: undefined: undefined", `output should refer to the generated file`) {
+			return
+		}
+	})
+	t.Run("TestFailure", func(t *testing.T) {
+		var m codegentest.Module
+		m.AddFile("greeter_gen.go", []byte("package greeter\n\nfunc Greet(s string) string {\n\treturn s\n}\n"))
+		m.AddFile("greeter_test.go", []byte(greeterTest))
+
+		output, err := m.Go("test", "-count=1", "./...")
+		if !assert.Error(t, err, `m.Go should fail`) {
+			return
+		}
+		if !assert.Contains(t, output, `unexpected greeting: "World"`, `output should contain the test failure`) {
+			return
+		}
+	})
+}
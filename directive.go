@@ -0,0 +1,87 @@
+package codegen
+
+import (
+	"bytes"
+	"fmt"
+	"go/build/constraint"
+	"strings"
+)
+
+// BuildConstraint adds a build constraint expression (e.g. "linux && !cgo")
+// to the generated code. It is rendered as a `//go:build` line above the
+// package clause when Write or WriteFile is called. Multiple constraints
+// are combined using `&&`.
+//
+// Use WithLegacyBuildTags to also emit the equivalent `// +build` lines
+// for toolchains older than Go 1.17.
+//
+// An invalid expression is recorded as an error, which is reported from
+// Err, Write and WriteFile
+func (o *Output) BuildConstraint(expr string) {
+	if o.err != nil {
+		return
+	}
+
+	x, err := constraint.Parse("//go:build " + expr)
+	if err != nil {
+		o.err = fmt.Errorf(`invalid build constraint %q: %w`, expr, err)
+		return
+	}
+
+	if o.buildConstraint == nil {
+		o.buildConstraint = x
+	} else {
+		o.buildConstraint = &constraint.AndExpr{X: o.buildConstraint, Y: x}
+	}
+}
+
+// Directive adds a file-level directive comment (e.g. "go:generate stringer -type=Kind",
+// or "nolint:all"), which is rendered directly above the package clause
+// when Write or WriteFile is called. The leading "//" may be omitted.
+func (o *Output) Directive(s string) {
+	if !strings.HasPrefix(s, "//") {
+		s = "//" + s
+	}
+	o.directives = append(o.directives, s)
+}
+
+// renderBuildConstraint renders the build constraint lines, followed by
+// a blank line so that they are not mistaken for a doc comment.
+// Returns nil if there are no build constraints
+func (o *Output) renderBuildConstraint(legacy bool) ([]byte, error) {
+	if o.buildConstraint == nil {
+		return nil, nil
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString("//go:build ")
+	buf.WriteString(o.buildConstraint.String())
+	buf.WriteByte('\n')
+	if legacy {
+		lines, err := constraint.PlusBuildLines(o.buildConstraint)
+		if err != nil {
+			return nil, fmt.Errorf(`failed to convert build constraint to +build lines: %w`, err)
+		}
+		for _, line := range lines {
+			buf.WriteString(line)
+			buf.WriteByte('\n')
+		}
+	}
+	buf.WriteByte('\n')
+	return buf.Bytes(), nil
+}
+
+// renderDirectives renders the directives, one per line.
+// Returns nil if there are no directives
+func (o *Output) renderDirectives() []byte {
+	if len(o.directives) == 0 {
+		return nil
+	}
+
+	var buf bytes.Buffer
+	for _, d := range o.directives {
+		buf.WriteString(d)
+		buf.WriteByte('\n')
+	}
+	return buf.Bytes()
+}
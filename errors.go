@@ -3,13 +3,19 @@ package codegen
 import (
 	"bufio"
 	"bytes"
+	"errors"
 	"fmt"
+	"go/scanner"
 	"math"
+	"regexp"
+	"strconv"
+	"strings"
 )
 
 type CodeFormatError struct {
-	src []byte
-	err error
+	src      []byte
+	err      error
+	dumpPath string
 }
 
 func codeFormatError(err error, src []byte) error {
@@ -21,21 +27,40 @@ func codeFormatError(err error, src []byte) error {
 		panic("invalid code format error: nil src passed")
 	}
 
-	return CodeFormatError {
+	return CodeFormatError{
 		err: err,
 		src: src,
 	}
 }
 
 func (err CodeFormatError) Error() string {
+	if err.dumpPath != "" {
+		return fmt.Sprintf(`%s (unformatted source written to %s)`, err.err.Error(), err.dumpPath)
+	}
 	return err.err.Error()
 }
 
+// DumpPath returns the name of the file that the unformatted source
+// was written to when WithDumpOnError is specified. Returns an empty
+// string if the source was not written anywhere
+func (err CodeFormatError) DumpPath() string {
+	return err.dumpPath
+}
+
+// Unwrap returns the error reported by the formatter
+func (err CodeFormatError) Unwrap() error {
+	return err.err
+}
+
 // Returns the source code with line numbers
 func (err CodeFormatError) Source() string {
-	n := bytes.Count(err.src, []byte{'\n'})
+	return numberedSource(err.src)
+}
+
+func numberedSource(src []byte) string {
+	n := bytes.Count(src, []byte{'\n'})
 	if n == 0 {
-		if len(err.src) > 0 {
+		if len(src) > 0 {
 			n = 1
 		}
 	}
@@ -45,13 +70,301 @@ func (err CodeFormatError) Source() string {
 
 	var dst bytes.Buffer
 
-	scanner := bufio.NewScanner(bytes.NewReader(err.src))
+	scanner := bufio.NewScanner(bytes.NewReader(src))
 	lineno := 1
 	for scanner.Scan() {
 		line := scanner.Text()
-		fmt.Fprintf(&dst, prefix + ": %s\n", lineno, line)
+		fmt.Fprintf(&dst, prefix+": %s\n", lineno, line)
 		lineno++
 	}
 
 	return dst.String()
 }
+
+// Positions returns the location of each of the errors reported
+// by the formatter. Positions are 1-based, and columns are counted
+// in bytes. Returns nil if no position information is available
+func (err CodeFormatError) Positions() []ErrorPosition {
+	return errorPositions(err.err)
+}
+
+// Snippet returns the lines surrounding each of the errors reported
+// by the formatter, with `contextLines` lines before and after the
+// failing line, and a caret pointing at the failing column.
+//
+// If no position information is available, the full numbered source
+// is returned, as with Source
+func (err CodeFormatError) Snippet(contextLines int) string {
+	positions := err.Positions()
+	if len(positions) == 0 {
+		return err.Source()
+	}
+	return snippet(err.src, positions, contextLines)
+}
+
+// ErrorPosition describes the location of an error in the generated code
+type ErrorPosition struct {
+	Filename string
+	Line     int
+	Column   int
+	Message  string
+}
+
+func (pos ErrorPosition) String() string {
+	var buf strings.Builder
+	if pos.Filename != "" {
+		buf.WriteString(pos.Filename)
+		buf.WriteByte(':')
+	}
+	fmt.Fprintf(&buf, "%d:%d: %s", pos.Line, pos.Column, pos.Message)
+	return buf.String()
+}
+
+// rxErrorPosition matches errors of the form "[filename:]line:column: message"
+var rxErrorPosition = regexp.MustCompile(`^(?:(.*):)?(\d+):(\d+): (.*)$`)
+
+func errorPositions(err error) []ErrorPosition {
+	var list scanner.ErrorList
+	if errors.As(err, &list) {
+		positions := make([]ErrorPosition, 0, len(list))
+		for _, e := range list {
+			positions = append(positions, ErrorPosition{
+				Filename: e.Pos.Filename,
+				Line:     e.Pos.Line,
+				Column:   e.Pos.Column,
+				Message:  e.Msg,
+			})
+		}
+		return positions
+	}
+
+	var single *scanner.Error
+	if errors.As(err, &single) {
+		return []ErrorPosition{{
+			Filename: single.Pos.Filename,
+			Line:     single.Pos.Line,
+			Column:   single.Pos.Column,
+			Message:  single.Msg,
+		}}
+	}
+
+	// Not all errors retain their type, so fall back to parsing
+	// positions from each line of the error message
+	var positions []ErrorPosition
+	for _, l := range strings.Split(err.Error(), "\n") {
+		m := rxErrorPosition.FindStringSubmatch(l)
+		if m == nil {
+			continue
+		}
+		line, _ := strconv.Atoi(m[2])
+		column, _ := strconv.Atoi(m[3])
+		positions = append(positions, ErrorPosition{
+			Filename: m[1],
+			Line:     line,
+			Column:   column,
+			Message:  m[4],
+		})
+	}
+	return positions
+}
+
+// snippet renders the lines surrounding each position, with a caret
+// under the failing column. Snippets are separated by an empty line
+func snippet(src []byte, positions []ErrorPosition, contextLines int) string {
+	if contextLines < 0 {
+		contextLines = 0
+	}
+
+	lines := strings.Split(string(src), "\n")
+	lineDigits := int(math.Log10(float64(len(lines)))) + 1
+	prefix := fmt.Sprintf("%%0%dd: ", lineDigits)
+
+	var dst strings.Builder
+	for i, pos := range positions {
+		if pos.Line < 1 || pos.Line > len(lines) {
+			continue
+		}
+
+		if i > 0 {
+			dst.WriteByte('\n')
+		}
+
+		first := pos.Line - contextLines
+		if first < 1 {
+			first = 1
+		}
+		last := pos.Line + contextLines
+		if last > len(lines) {
+			last = len(lines)
+		}
+
+		for lineno := first; lineno <= last; lineno++ {
+			line := lines[lineno-1]
+			fmt.Fprintf(&dst, prefix+"%s\n", lineno, line)
+			if lineno != pos.Line {
+				continue
+			}
+
+			// align the caret, preserving tabs so that it lines up
+			// with the failing column regardless of the tab width
+			dst.WriteString(strings.Repeat(" ", lineDigits+2))
+			for j := 0; j < pos.Column-1 && j < len(line); j++ {
+				if line[j] == '\t' {
+					dst.WriteByte('\t')
+				} else {
+					dst.WriteByte(' ')
+				}
+			}
+			fmt.Fprintf(&dst, "^ %s\n", pos.Message)
+		}
+	}
+	return dst.String()
+}
+
+// StaleError is returned from CheckFile when the contents of the
+// generated files on disk differ from the code that would be generated
+type StaleError struct {
+	files []string
+}
+
+func staleError(files ...string) error {
+	return StaleError{files: files}
+}
+
+func (err StaleError) Error() string {
+	return fmt.Sprintf(`generated files are out of date: %s`, strings.Join(err.files, ", "))
+}
+
+// Files returns the names of the files that are out of date
+func (err StaleError) Files() []string {
+	return err.files
+}
+
+// DecodeError is returned when a spec can not be decoded. It records
+// the path to the value that failed to decode (e.g. `objects[2].fields[3].type`)
+// and its location in the source.
+//
+// When decoding with DecodeJSON or DecodeJSONC, the path and the
+// position are relative to the whole document. When decoding with
+// json.Unmarshal, they are
+// relative to the outermost value whose UnmarshalJSON method reported the
+// error, which may be nested in the document (e.g. an ObjectList stored
+// in a struct field): in that case the path starts at that value (e.g.
+// `[2].fields[3].type`), and the position is not included in the message
+type DecodeError struct {
+	path     string
+	offset   int64
+	line     int
+	column   int
+	err      error
+	src      []byte // the value that offset is relative to
+	document bool   // true if src is the whole document
+}
+
+// decodeError wraps err with the path element `elem` of the value that
+// starts at `offset` in `data`. If err already contains a DecodeError
+// from a nested value, the paths are joined and the position is adjusted
+// to be relative to `data`
+func decodeError(data []byte, elem string, offset int64, err error) error {
+	var de DecodeError
+	if errors.As(err, &de) {
+		de.path = joinDecodePath(elem, de.path)
+		de.offset += offset
+	} else {
+		de = DecodeError{
+			path:   elem,
+			offset: offset,
+			err:    err,
+		}
+	}
+	de.src = data
+	de.line, de.column = lineColumn(data, de.offset)
+	return de
+}
+
+func joinDecodePath(parent, child string) string {
+	switch {
+	case parent == "":
+		return child
+	case child == "":
+		return parent
+	case strings.HasPrefix(child, "["):
+		return parent + child
+	default:
+		return parent + "." + child
+	}
+}
+
+func (err DecodeError) Error() string {
+	switch {
+	case !err.document && err.path == "":
+		return err.err.Error()
+	case !err.document:
+		return fmt.Sprintf(`%s: %s`, err.path, err.err.Error())
+	case err.path == "":
+		return fmt.Sprintf(`line %d, column %d: %s`, err.line, err.column, err.err.Error())
+	default:
+		return fmt.Sprintf(`%s (line %d, column %d): %s`, err.path, err.line, err.column, err.err.Error())
+	}
+}
+
+// Path returns the path to the value that failed to decode, such as
+// `fields[3].type`. Returns an empty string if the error occurred
+// at the top level
+func (err DecodeError) Path() string {
+	return err.path
+}
+
+// IsDocumentPosition returns true if Offset, Line, and Column are
+// relative to the beginning of the document, rather than to a value
+// nested in it
+func (err DecodeError) IsDocumentPosition() bool {
+	return err.document
+}
+
+// Offset returns the byte offset of the value that failed to decode
+func (err DecodeError) Offset() int64 {
+	return err.offset
+}
+
+// Line returns the 1-based line of the value that failed to decode
+func (err DecodeError) Line() int {
+	return err.line
+}
+
+// Column returns the 1-based column, counted in bytes, of the value
+// that failed to decode
+func (err DecodeError) Column() int {
+	return err.column
+}
+
+// Unwrap returns the underlying error
+func (err DecodeError) Unwrap() error {
+	return err.err
+}
+
+// UnknownKeyError is reported when decoding in strict mode, if an
+// object or a field contains a key that is neither known nor allowed
+type UnknownKeyError struct {
+	key        string
+	suggestion string
+}
+
+func (err UnknownKeyError) Error() string {
+	if err.suggestion != "" {
+		return fmt.Sprintf(`unknown key %q (did you mean %q?)`, err.key, err.suggestion)
+	}
+	return fmt.Sprintf(`unknown key %q`, err.key)
+}
+
+// Key returns the unknown key
+func (err UnknownKeyError) Key() string {
+	return err.key
+}
+
+// Suggestion returns the known or allowed key that is most similar
+// to the unknown key. Returns an empty string if there are no
+// similar keys
+func (err UnknownKeyError) Suggestion() string {
+	return err.suggestion
+}
@@ -0,0 +1,87 @@
+package codegen
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+const defaultFileMode os.FileMode = 0644
+
+// brokenSuffix is appended to the name of the target file when
+// writing out code that could not be formatted
+const brokenSuffix = ".broken"
+
+// OSFS is the FS backed by the operating system's file system.
+// This is the default used by WriteFile.
+type OSFS struct{}
+
+func (OSFS) ReadFile(name string) ([]byte, error) {
+	return ioutil.ReadFile(name)
+}
+
+// WriteFile writes the data to a temporary file in the same directory,
+// and renames it to `name` once it has been completely written
+func (OSFS) WriteFile(name string, data []byte) error {
+	if dir := filepath.Dir(name); dir != "." {
+		if _, err := os.Stat(dir); err != nil {
+			if err := os.MkdirAll(dir, 0755); err != nil {
+				return fmt.Errorf(`failed to create directory %q: %w`, dir, err)
+			}
+		}
+	}
+	return writeFileAtomic(name, data)
+}
+
+func (OSFS) Remove(name string) error {
+	return os.Remove(name)
+}
+
+// writeFileAtomic writes data to a temporary file in the same directory
+// as filename, and renames it to filename once it has been successfully
+// written. The permissions of an existing file are preserved
+func writeFileAtomic(filename string, data []byte) error {
+	mode := defaultFileMode
+	if fi, err := os.Stat(filename); err == nil {
+		mode = fi.Mode().Perm()
+	}
+
+	dir, base := filepath.Split(filename)
+	if dir == "" {
+		dir = "."
+	}
+
+	tmp, err := ioutil.TempFile(dir, "."+base+".*.tmp")
+	if err != nil {
+		return fmt.Errorf(`failed to create temporary file for %s: %w`, filename, err)
+	}
+	tmpname := tmp.Name()
+
+	// remove the temporary file, unless it has been renamed
+	renamed := false
+	defer func() {
+		if !renamed {
+			_ = os.Remove(tmpname)
+		}
+	}()
+
+	if _, err := tmp.Write(data); err != nil {
+		_ = tmp.Close()
+		return fmt.Errorf(`failed to write to temporary file for %s: %w`, filename, err)
+	}
+
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf(`failed to close temporary file for %s: %w`, filename, err)
+	}
+
+	if err := os.Chmod(tmpname, mode); err != nil {
+		return fmt.Errorf(`failed to change permissions of temporary file for %s: %w`, filename, err)
+	}
+
+	if err := os.Rename(tmpname, filename); err != nil {
+		return fmt.Errorf(`failed to rename temporary file to %s: %w`, filename, err)
+	}
+	renamed = true
+	return nil
+}
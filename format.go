@@ -0,0 +1,212 @@
+package codegen
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/printer"
+	"go/token"
+	"sort"
+	"strconv"
+	"strings"
+
+	"golang.org/x/tools/go/ast/astutil"
+	"golang.org/x/tools/imports"
+)
+
+// Formatter specifies the tool used to format the generated code
+type Formatter int
+
+const (
+	// FormatGoimports formats the code and adjusts the imports, just
+	// like goimports does. This is the default
+	FormatGoimports Formatter = iota
+	// FormatGofmt only formats the code, just like gofmt does. This is
+	// much faster than FormatGoimports, but missing imports are not added
+	FormatGofmt
+)
+
+const defaultTabWidth = 8
+
+// formatConfig holds the options that control how code is formatted
+type formatConfig struct {
+	formatter   Formatter
+	filename    string
+	localPrefix string
+	formatOnly  bool
+	tabWidth    int
+}
+
+func (c *formatConfig) format(src []byte) ([]byte, error) {
+	tabWidth := c.tabWidth
+	if tabWidth <= 0 {
+		tabWidth = defaultTabWidth
+	}
+
+	switch c.formatter {
+	case FormatGofmt:
+		return gofmt(c.filename, src, tabWidth)
+	case FormatGoimports:
+		// imports.LocalPrefix is a global variable, so instead of
+		// changing it (which would prevent files from being formatted
+		// concurrently), the local imports are grouped separately
+		buf, err := imports.Process(c.filename, src, &imports.Options{
+			Comments:   true,
+			TabIndent:  true,
+			TabWidth:   tabWidth,
+			FormatOnly: c.formatOnly,
+		})
+		if err != nil || c.localPrefix == "" {
+			return buf, err
+		}
+		return groupLocalImports(c.filename, buf, c.localPrefix)
+	default:
+		return nil, fmt.Errorf(`unknown formatter %d`, c.formatter)
+	}
+}
+
+func gofmt(filename string, src []byte, tabWidth int) ([]byte, error) {
+	if tabWidth == defaultTabWidth {
+		return format.Source(src)
+	}
+
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, filename, src, parser.ParseComments)
+	if err != nil {
+		return nil, err
+	}
+	ast.SortImports(fset, f)
+
+	var buf bytes.Buffer
+	cfg := printer.Config{Mode: printer.UseSpaces | printer.TabIndent, Tabwidth: tabWidth}
+	if err := cfg.Fprint(&buf, fset, f); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// groupLocalImports moves imports that start with one of the
+// comma-separated prefixes in `localPrefix` into their own group, after
+// the third-party imports, in the same way that goimports does when
+// imports.LocalPrefix is set.
+//
+// As with goimports, imports are only reordered within each block of
+// consecutive import lines. Blocks that contain doc comments or comments
+// that span multiple lines are left alone
+func groupLocalImports(filename string, src []byte, localPrefix string) ([]byte, error) {
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, filename, src, parser.ParseComments|parser.ImportsOnly)
+	if err != nil {
+		return nil, err
+	}
+
+	var dst []byte
+	var prev int
+	var changed bool
+	for _, block := range astutil.Imports(fset, f) {
+		lines, start, end, ok := importLines(fset, src, block, localPrefix)
+		if !ok {
+			continue
+		}
+
+		sort.SliceStable(lines, func(i, j int) bool {
+			return lines[i].group < lines[j].group
+		})
+
+		dst = append(dst, src[prev:start]...)
+		for i, l := range lines {
+			if i > 0 {
+				dst = append(dst, '\n')
+				if l.group != lines[i-1].group {
+					dst = append(dst, '\n')
+				}
+			}
+			dst = append(dst, l.text...)
+		}
+		prev = end
+		changed = true
+	}
+
+	if !changed {
+		return src, nil
+	}
+	return append(dst, src[prev:]...), nil
+}
+
+type importLine struct {
+	group int
+	text  []byte
+}
+
+// importLines returns the lines of the import specs in `block`, along
+// with the offsets of the beginning of the first line and the end of
+// the last line. Returns false if the block does not need to be
+// regrouped, or can not be regrouped safely
+func importLines(fset *token.FileSet, src []byte, block []*ast.ImportSpec, localPrefix string) ([]importLine, int, int, bool) {
+	var lines []importLine
+	var hasLocal bool
+	prevEnd := -1
+	for _, spec := range block {
+		if spec.Doc != nil {
+			return nil, 0, 0, false
+		}
+
+		line := fset.Position(spec.Path.Pos()).Line
+		if spec.Comment != nil && fset.Position(spec.Comment.End()).Line != line {
+			return nil, 0, 0, false
+		}
+
+		offset := fset.Position(spec.Path.Pos()).Offset
+		start := bytes.LastIndexByte(src[:offset], '\n') + 1
+		end := bytes.IndexByte(src[offset:], '\n')
+		if end < 0 {
+			end = len(src)
+		} else {
+			end += offset
+		}
+		if start < prevEnd {
+			// more than one spec on the same line
+			return nil, 0, 0, false
+		}
+		prevEnd = end
+
+		path, err := strconv.Unquote(spec.Path.Value)
+		if err != nil {
+			return nil, 0, 0, false
+		}
+		group := importGroup(localPrefix, path)
+		if group == localImportGroup {
+			hasLocal = true
+		}
+		lines = append(lines, importLine{group: group, text: src[start:end]})
+	}
+
+	if !hasLocal {
+		return nil, 0, 0, false
+	}
+
+	first := fset.Position(block[0].Path.Pos()).Offset
+	return lines, bytes.LastIndexByte(src[:first], '\n') + 1, prevEnd, true
+}
+
+const localImportGroup = 3
+
+// importGroup returns the group that goimports places the import in:
+// standard library packages, third-party packages, appengine packages,
+// and packages matching the local prefix, in that order
+func importGroup(localPrefix, pkgPath string) int {
+	for _, p := range strings.Split(localPrefix, ",") {
+		if p != "" && (strings.HasPrefix(pkgPath, p) || strings.TrimSuffix(p, "/") == pkgPath) {
+			return localImportGroup
+		}
+	}
+	if strings.HasPrefix(pkgPath, "appengine") {
+		return 2
+	}
+	if first := strings.SplitN(pkgPath, "/", 2)[0]; strings.Contains(first, ".") {
+		return 1
+	}
+	return 0
+}
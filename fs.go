@@ -0,0 +1,143 @@
+package codegen
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+// FS is the file system that generated files are written to.
+// Implementations must be safe for concurrent use.
+type FS interface {
+	// ReadFile returns the contents of the file `name`. If the file
+	// does not exist, the error must satisfy os.IsNotExist
+	ReadFile(name string) ([]byte, error)
+	// WriteFile replaces the contents of the file `name` with `data`,
+	// creating the file and its parent directories as necessary.
+	// Readers must never observe a partially written file
+	WriteFile(name string, data []byte) error
+	// Remove removes the file `name`
+	Remove(name string) error
+}
+
+// fsFromOptions returns the file system specified using WithFS,
+// or the OS file system if none was specified
+func fsFromOptions(options []Option) FS {
+	var fs FS = OSFS{}
+	for _, option := range options {
+		switch option.Ident() {
+		case identFS{}:
+			fs = option.Value().(FS)
+		}
+	}
+	return fs
+}
+
+// MemFS is an in-memory FS. It can be used to generate code without
+// touching the disk, for example to inspect the results in tests, or
+// to bundle them into an archive.
+type MemFS struct {
+	mu    sync.RWMutex
+	files map[string][]byte
+}
+
+func NewMemFS() *MemFS {
+	return &MemFS{
+		files: make(map[string][]byte),
+	}
+}
+
+func (fs *MemFS) ReadFile(name string) ([]byte, error) {
+	fs.mu.RLock()
+	defer fs.mu.RUnlock()
+
+	data, ok := fs.files[filepath.Clean(name)]
+	if !ok {
+		return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrNotExist}
+	}
+	return append([]byte(nil), data...), nil
+}
+
+func (fs *MemFS) WriteFile(name string, data []byte) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	fs.files[filepath.Clean(name)] = append([]byte(nil), data...)
+	return nil
+}
+
+func (fs *MemFS) Remove(name string) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	name = filepath.Clean(name)
+	if _, ok := fs.files[name]; !ok {
+		return &os.PathError{Op: "remove", Path: name, Err: os.ErrNotExist}
+	}
+	delete(fs.files, name)
+	return nil
+}
+
+// Files returns the sorted list of files in the file system
+func (fs *MemFS) Files() []string {
+	fs.mu.RLock()
+	defer fs.mu.RUnlock()
+
+	list := make([]string, 0, len(fs.files))
+	for name := range fs.files {
+		list = append(list, name)
+	}
+	sort.Strings(list)
+	return list
+}
+
+// WriteZip writes all of the files to `w` as a zip archive.
+// Files are written in sorted order
+func (fs *MemFS) WriteZip(w io.Writer) error {
+	zw := zip.NewWriter(w)
+	for _, name := range fs.Files() {
+		data, err := fs.ReadFile(name)
+		if err != nil {
+			return err
+		}
+
+		fw, err := zw.Create(filepath.ToSlash(name))
+		if err != nil {
+			return fmt.Errorf(`failed to add %s to zip archive: %w`, name, err)
+		}
+		if _, err := fw.Write(data); err != nil {
+			return fmt.Errorf(`failed to write %s to zip archive: %w`, name, err)
+		}
+	}
+	return zw.Close()
+}
+
+// WriteTar writes all of the files to `w` as a tar archive.
+// Files are written in sorted order
+func (fs *MemFS) WriteTar(w io.Writer) error {
+	tw := tar.NewWriter(w)
+	for _, name := range fs.Files() {
+		data, err := fs.ReadFile(name)
+		if err != nil {
+			return err
+		}
+
+		hdr := &tar.Header{
+			Name: filepath.ToSlash(name),
+			Mode: int64(defaultFileMode),
+			Size: int64(len(data)),
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return fmt.Errorf(`failed to add %s to tar archive: %w`, name, err)
+		}
+		if _, err := tw.Write(data); err != nil {
+			return fmt.Errorf(`failed to write %s to tar archive: %w`, name, err)
+		}
+	}
+	return tw.Close()
+}
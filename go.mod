@@ -1,11 +1,11 @@
 module github.com/lestrrat-go/codegen
 
-go 1.14
+go 1.16
 
 require (
 	github.com/lestrrat-go/option v1.0.0
 	github.com/lestrrat-go/xstrings v0.0.0-20210804220435-4dd8b234342b
 	github.com/stretchr/testify v1.7.1
 	golang.org/x/tools v0.0.0-20200918232735-d647fc253266
-	gopkg.in/yaml.v3 v3.0.0 // indirect
+	gopkg.in/yaml.v3 v3.0.0
 )
@@ -0,0 +1,72 @@
+package codegen
+
+import (
+	"bytes"
+	"fmt"
+	"go/scanner"
+	"go/token"
+	"regexp"
+)
+
+// rxGeneratedMarker matches the line that marks a file as generated,
+// as described in https://golang.org/s/generatedcode
+var rxGeneratedMarker = regexp.MustCompile(`^// Code generated .* DO NOT EDIT\.$`)
+
+// GeneratedHeader returns the standard comment line that marks a file
+// as generated by `tool`. If `source` is non-empty, it is mentioned as
+// the file that the code was generated from.
+func GeneratedHeader(tool, source string) string {
+	if source != "" {
+		return fmt.Sprintf(`// Code generated by %s from %s; DO NOT EDIT.`, tool, source)
+	}
+	return fmt.Sprintf(`// Code generated by %s; DO NOT EDIT.`, tool)
+}
+
+// IsGenerated returns true if the source contains the standard
+// comment line that marks a file as generated. As required by
+// https://golang.org/s/generatedcode, the line must appear before the
+// first non-comment, non-blank text (usually the package clause), so
+// the same text in a string literal or in a comment in the body of
+// the file is ignored
+func IsGenerated(src []byte) bool {
+	fset := token.NewFileSet()
+	file := fset.AddFile("", -1, len(src))
+
+	var s scanner.Scanner
+	s.Init(file, src, nil, scanner.ScanComments)
+	for {
+		_, tok, lit := s.Scan()
+		if tok != token.COMMENT {
+			return false
+		}
+		if rxGeneratedMarker.MatchString(lit) {
+			return true
+		}
+	}
+}
+
+// WriteGeneratedHeader writes the standard comment line that marks
+// the file as generated. It should be called before WritePackage.
+//
+// See also WithGeneratedHeader
+func (o *Output) WriteGeneratedHeader(tool, source string) {
+	o.R(GeneratedHeader(tool, source))
+	o.R("\n")
+}
+
+// addGeneratedHeader prepends the header line to src, unless src is
+// already marked as generated
+func addGeneratedHeader(src []byte, tool, source string) []byte {
+	if IsGenerated(src) {
+		return src
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString(GeneratedHeader(tool, source))
+	buf.WriteByte('\n')
+	if !bytes.HasPrefix(src, []byte{'\n'}) {
+		buf.WriteByte('\n')
+	}
+	buf.Write(src)
+	return buf.Bytes()
+}
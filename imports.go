@@ -0,0 +1,182 @@
+package codegen
+
+import (
+	"bytes"
+	"fmt"
+	"go/token"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// importSet keeps track of the packages referred to from an Output,
+// and the names that they are referred to by
+type importSet struct {
+	byPath map[string]*trackedImport
+	byName map[string]string // name -> import path
+}
+
+type trackedImport struct {
+	path string
+	name string
+	// true if the import statement has already been written
+	// explicitly via WriteImports/WriteImportPkgs
+	written bool
+}
+
+func newImportSet() *importSet {
+	return &importSet{
+		byPath: make(map[string]*trackedImport),
+		byName: make(map[string]string),
+	}
+}
+
+// add registers the import path, and returns the name that should
+// be used to refer to it. If alias is non-empty it is used as the
+// name, otherwise a name is derived from the import path, with a
+// numeric suffix appended if it clashes with another package.
+//
+// An error is returned if the alias is already used by another
+// package, or if the package has already been given a different name
+func (s *importSet) add(pkgPath, alias string) (*trackedImport, error) {
+	if imp, ok := s.byPath[pkgPath]; ok {
+		if alias != "" && alias != imp.name {
+			return nil, fmt.Errorf(`package %q is already referred to as %q, can not use alias %q`, pkgPath, imp.name, alias)
+		}
+		return imp, nil
+	}
+
+	name := alias
+	if name == "" {
+		base := assumedPackageName(pkgPath)
+		name = base
+		for i := 2; ; i++ {
+			if _, ok := s.byName[name]; !ok {
+				break
+			}
+			name = base + strconv.Itoa(i)
+		}
+	} else if other, ok := s.byName[name]; ok {
+		return nil, fmt.Errorf(`alias %q for package %q is already used by package %q`, name, pkgPath, other)
+	}
+
+	imp := &trackedImport{
+		path: pkgPath,
+		name: name,
+	}
+	s.byPath[pkgPath] = imp
+	if name != "_" && name != "." {
+		s.byName[name] = pkgPath
+	}
+	return imp, nil
+}
+
+// pending returns the list of imports that have not been written yet
+func (s *importSet) pending() []*trackedImport {
+	var list []*trackedImport
+	for _, imp := range s.byPath {
+		if !imp.written {
+			list = append(list, imp)
+		}
+	}
+	return list
+}
+
+// render creates the import block for imports that have not been
+// written explicitly. Standard library packages are grouped first,
+// followed by everything else. Returns nil if there is nothing to write
+func (s *importSet) render() []byte {
+	list := s.pending()
+	if len(list) == 0 {
+		return nil
+	}
+
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].path < list[j].path
+	})
+
+	var std, others []*trackedImport
+	for _, imp := range list {
+		if isStdlib(imp.path) {
+			std = append(std, imp)
+		} else {
+			others = append(others, imp)
+		}
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString("\n\nimport (")
+	for i, group := range [][]*trackedImport{std, others} {
+		if len(group) == 0 {
+			continue
+		}
+		if i > 0 && len(std) > 0 {
+			buf.WriteByte('\n')
+		}
+		for _, imp := range group {
+			if imp.name != path.Base(imp.path) {
+				fmt.Fprintf(&buf, "\n\t%s %q", imp.name, imp.path)
+			} else {
+				fmt.Fprintf(&buf, "\n\t%q", imp.path)
+			}
+		}
+	}
+	buf.WriteString("\n)")
+	return buf.Bytes()
+}
+
+func isStdlib(pkgPath string) bool {
+	first := pkgPath
+	if i := strings.IndexByte(pkgPath, '/'); i >= 0 {
+		first = pkgPath[:i]
+	}
+	return !strings.Contains(first, ".")
+}
+
+// assumedPackageName returns the name that is used to refer to the
+// package when no alias is given. This is the name that goimports would
+// assume (see importPathName), adjusted to be a valid identifier:
+// keywords get an underscore suffix ("go_"), names starting with a
+// digit get an underscore prefix ("_123abc"), and "pkg" is used if
+// nothing is left
+func assumedPackageName(pkgPath string) string {
+	name := importPathName(pkgPath)
+	switch {
+	case name == "" || name == "_":
+		return "pkg"
+	case token.IsKeyword(name):
+		return name + "_"
+	case unicode.IsDigit([]rune(name)[0]):
+		return "_" + name
+	}
+	return name
+}
+
+// importPathName returns the package name that would be assumed
+// from the import path, following the same heuristics as goimports:
+// major version suffixes are skipped, a "go-" prefix is removed, and
+// the name is cut at the first character that cannot appear in an
+// identifier (e.g. "gopkg.in/yaml.v3" becomes "yaml"). The result
+// may not be a valid identifier.
+//
+// The package itself is never consulted, so no network or GOPATH
+// lookups are performed
+func importPathName(pkgPath string) string {
+	base := path.Base(pkgPath)
+	if strings.HasPrefix(base, "v") {
+		if _, err := strconv.Atoi(base[1:]); err == nil {
+			if dir := path.Dir(pkgPath); dir != "." {
+				base = path.Base(dir)
+			}
+		}
+	}
+	base = strings.TrimPrefix(base, "go-")
+	if i := strings.IndexFunc(base, func(r rune) bool {
+		return !(r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r))
+	}); i >= 0 {
+		base = base[:i]
+	}
+	return base
+}
@@ -0,0 +1,283 @@
+// Package diff implements a line based diff, which is rendered
+// in the unified format.
+package diff
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// DefaultContext is the number of unchanged lines shown around each change
+const DefaultContext = 3
+
+type opKind int
+
+const (
+	opEqual opKind = iota
+	opDelete
+	opInsert
+)
+
+// op is a single step in the edit script. `a` and `b` are the
+// indices of the lines in the old and new inputs, respectively.
+// For insertions `a` is the index in the old input where the line
+// is inserted, and for deletions `b` is the index in the new input
+type op struct {
+	kind opKind
+	a, b int
+}
+
+// Unified returns the differences between `old` and `new` in the
+// unified format, labeled with `oldName` and `newName`. Returns nil
+// if the inputs are identical
+func Unified(oldName, newName string, old, new []byte) []byte {
+	if bytes.Equal(old, new) {
+		return nil
+	}
+
+	a := splitLines(old)
+	b := splitLines(new)
+	ops := compute(a, b)
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "--- %s\n+++ %s\n", oldName, newName)
+	for _, h := range hunks(ops, DefaultContext) {
+		writeHunk(&buf, a, b, h)
+	}
+	return buf.Bytes()
+}
+
+// splitLines splits the input into lines, each of which retains its
+// line terminator. Only the last line may lack a terminator
+func splitLines(src []byte) []string {
+	var lines []string
+	for len(src) > 0 {
+		i := bytes.IndexByte(src, '\n')
+		if i < 0 {
+			lines = append(lines, string(src))
+			break
+		}
+		lines = append(lines, string(src[:i+1]))
+		src = src[i+1:]
+	}
+	return lines
+}
+
+// compute calculates the shortest edit script that turns `a` into `b`
+// using the algorithm described in "An O(ND) Difference Algorithm and
+// Its Variations" by Eugene W. Myers.
+//
+// The linear space refinement from the same paper is used: instead of
+// keeping the state of every round to trace back the path, the "middle
+// snake" of the path is searched from both ends, and the parts before
+// and after it are computed recursively. This keeps memory usage
+// proportional to the size of the inputs, even when they have nothing
+// in common
+func compute(a, b []string) []op {
+	// diagonals range from -(max+1) to max+1 in middleSnake
+	size := len(a) + len(b) + 4
+	d := &differ{
+		a:   a,
+		b:   b,
+		vf:  make([]int, size),
+		vb:  make([]int, size),
+		ops: make([]op, 0, len(a)+len(b)),
+	}
+	d.compute(0, len(a), 0, len(b))
+	return d.ops
+}
+
+type differ struct {
+	a, b   []string
+	vf, vb []int // furthest reaching paths, forward and backward
+	ops    []op
+}
+
+// compute appends the edit script that turns a[aLo:aHi] into b[bLo:bHi]
+func (d *differ) compute(aLo, aHi, bLo, bHi int) {
+	for aLo < aHi && bLo < bHi && d.a[aLo] == d.b[bLo] {
+		d.ops = append(d.ops, op{kind: opEqual, a: aLo, b: bLo})
+		aLo++
+		bLo++
+	}
+
+	var suffix int
+	for aLo < aHi-suffix && bLo < bHi-suffix && d.a[aHi-suffix-1] == d.b[bHi-suffix-1] {
+		suffix++
+	}
+	aHi -= suffix
+	bHi -= suffix
+
+	switch {
+	case aLo == aHi:
+		for y := bLo; y < bHi; y++ {
+			d.ops = append(d.ops, op{kind: opInsert, a: aLo, b: y})
+		}
+	case bLo == bHi:
+		for x := aLo; x < aHi; x++ {
+			d.ops = append(d.ops, op{kind: opDelete, a: x, b: bLo})
+		}
+	default:
+		// as the common prefix and suffix have been removed, the edit
+		// script contains at least two edits, so both parts around the
+		// middle snake are smaller than the whole
+		x, y, u, v := d.middleSnake(aLo, aHi, bLo, bHi)
+		d.compute(aLo, x, bLo, y)
+		for ; x < u; x, y = x+1, y+1 {
+			d.ops = append(d.ops, op{kind: opEqual, a: x, b: y})
+		}
+		d.compute(u, aHi, v, bHi)
+	}
+
+	for i := 0; i < suffix; i++ {
+		d.ops = append(d.ops, op{kind: opEqual, a: aHi + i, b: bHi + i})
+	}
+}
+
+// middleSnake finds the middle snake of the shortest edit script that
+// turns a[aLo:aHi] into b[bLo:bHi], by running the search forward from
+// the beginning and backward from the end until the paths overlap.
+// Returns the absolute positions of the beginning (x, y) and the end
+// (u, v) of the snake
+func (d *differ) middleSnake(aLo, aHi, bLo, bHi int) (x, y, u, v int) {
+	n, m := aHi-aLo, bHi-bLo
+	delta := n - m
+	odd := delta%2 != 0
+	max := (n + m + 1) / 2
+	offset := max + 1
+
+	// vf[offset+k] is the furthest x reached on diagonal k going forward,
+	// and vb[offset+k] is the same going backward, where x and k are
+	// measured from the end of the inputs
+	vf, vb := d.vf, d.vb
+	vf[offset+1] = 0
+	vb[offset+1] = 0
+	for D := 0; D <= max; D++ {
+		for k := -D; k <= D; k += 2 {
+			var x int
+			if k == -D || (k != D && vf[offset+k-1] < vf[offset+k+1]) {
+				x = vf[offset+k+1]
+			} else {
+				x = vf[offset+k-1] + 1
+			}
+			y := x - k
+			x0, y0 := x, y
+			for x < n && y < m && d.a[aLo+x] == d.b[bLo+y] {
+				x++
+				y++
+			}
+			vf[offset+k] = x
+
+			if rk := delta - k; odd && rk >= -(D-1) && rk <= D-1 && x+vb[offset+rk] >= n {
+				return aLo + x0, bLo + y0, aLo + x, bLo + y
+			}
+		}
+
+		for k := -D; k <= D; k += 2 {
+			var x int
+			if k == -D || (k != D && vb[offset+k-1] < vb[offset+k+1]) {
+				x = vb[offset+k+1]
+			} else {
+				x = vb[offset+k-1] + 1
+			}
+			y := x - k
+			x0, y0 := x, y
+			for x < n && y < m && d.a[aHi-x-1] == d.b[bHi-y-1] {
+				x++
+				y++
+			}
+			vb[offset+k] = x
+
+			if fk := delta - k; !odd && fk >= -D && fk <= D && x+vf[offset+fk] >= n {
+				return aHi - x, bHi - y, aHi - x0, bHi - y0
+			}
+		}
+	}
+
+	// unreachable: the paths always overlap within max rounds
+	panic("diff: middle snake not found")
+}
+
+// hunks splits the edit script into groups of changes, each surrounded
+// by at most `context` unchanged lines. Changes that are separated by
+// no more than 2*context unchanged lines are merged into a single hunk
+func hunks(ops []op, context int) [][]op {
+	var list [][]op
+	for i := 0; i < len(ops); {
+		if ops[i].kind == opEqual {
+			i++
+			continue
+		}
+
+		start := i - context
+		if start < 0 {
+			start = 0
+		}
+
+		end := i // index of the last change in this hunk
+		for j := end + 1; j < len(ops) && j-end-1 <= 2*context; j++ {
+			if ops[j].kind != opEqual {
+				end = j
+			}
+		}
+
+		stop := end + context + 1
+		if stop > len(ops) {
+			stop = len(ops)
+		}
+		list = append(list, ops[start:stop])
+		i = stop
+	}
+	return list
+}
+
+func writeHunk(buf *bytes.Buffer, a, b []string, h []op) {
+	aStart, bStart := h[0].a, h[0].b
+	var aCount, bCount int
+	for _, o := range h {
+		switch o.kind {
+		case opEqual:
+			aCount++
+			bCount++
+		case opDelete:
+			aCount++
+		case opInsert:
+			bCount++
+		}
+	}
+
+	fmt.Fprintf(buf, "@@ -%s +%s @@\n", hunkRange(aStart, aCount), hunkRange(bStart, bCount))
+	for _, o := range h {
+		switch o.kind {
+		case opEqual:
+			writeLine(buf, ' ', a[o.a])
+		case opDelete:
+			writeLine(buf, '-', a[o.a])
+		case opInsert:
+			writeLine(buf, '+', b[o.b])
+		}
+	}
+}
+
+// hunkRange formats the range of a hunk. `start` is the zero based
+// index of the first line. Following the convention of GNU diff,
+// an empty range refers to the line before it, and the count is
+// omitted if it is 1
+func hunkRange(start, count int) string {
+	switch count {
+	case 0:
+		return fmt.Sprintf("%d,0", start)
+	case 1:
+		return fmt.Sprintf("%d", start+1)
+	default:
+		return fmt.Sprintf("%d,%d", start+1, count)
+	}
+}
+
+func writeLine(buf *bytes.Buffer, prefix byte, line string) {
+	buf.WriteByte(prefix)
+	buf.WriteString(line)
+	if len(line) == 0 || line[len(line)-1] != '\n' {
+		buf.WriteString("\n\\ No newline at end of file\n")
+	}
+}
@@ -0,0 +1,164 @@
+package diff_test
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/lestrrat-go/codegen/internal/diff"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUnified(t *testing.T) {
+	testcases := []struct {
+		Name     string
+		Old      string
+		New      string
+		Expected string
+	}{
+		{
+			Name: `identical`,
+			Old:  "a\nb\n",
+			New:  "a\nb\n",
+		},
+		{
+			Name:     `new file`,
+			Old:      "",
+			New:      "a\nb\n",
+			Expected: "--- old\n+++ new\n@@ -0,0 +1,2 @@\n+a\n+b\n",
+		},
+		{
+			Name:     `change in the middle`,
+			Old:      "1\n2\n3\n4\n5\n6\n7\n8\n9\n",
+			New:      "1\n2\n3\n4\nfive\n6\n7\n8\n9\n",
+			Expected: "--- old\n+++ new\n@@ -2,7 +2,7 @@\n 2\n 3\n 4\n-5\n+five\n 6\n 7\n 8\n",
+		},
+		{
+			Name:     `separate hunks`,
+			Old:      "1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n",
+			New:      "one\n2\n3\n4\n5\n6\n7\n8\n9\nten\n",
+			Expected: "--- old\n+++ new\n@@ -1,4 +1,4 @@\n-1\n+one\n 2\n 3\n 4\n@@ -7,4 +7,4 @@\n 7\n 8\n 9\n-10\n+ten\n",
+		},
+		{
+			Name:     `missing newline at end of file`,
+			Old:      "a\nb",
+			New:      "a\nb\n",
+			Expected: "--- old\n+++ new\n@@ -1,2 +1,2 @@\n a\n-b\n\\ No newline at end of file\n+b\n",
+		},
+	}
+
+	for _, tc := range testcases {
+		tc := tc
+		t.Run(tc.Name, func(t *testing.T) {
+			got := diff.Unified("old", "new", []byte(tc.Old), []byte(tc.New))
+			if !assert.Equal(t, tc.Expected, string(got), `diff should match`) {
+				return
+			}
+		})
+	}
+}
+
+func TestUnifiedRandom(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	randomLines := func() []string {
+		lines := make([]string, rng.Intn(30))
+		for i := range lines {
+			lines[i] = string(rune('a'+rng.Intn(4))) + "\n"
+		}
+		return lines
+	}
+
+	for i := 0; i < 500; i++ {
+		a, b := randomLines(), randomLines()
+		old, new := strings.Join(a, ""), strings.Join(b, "")
+		got := string(diff.Unified("old", "new", []byte(old), []byte(new)))
+
+		var edits int
+		for _, line := range strings.Split(got, "\n")[2:] {
+			if strings.HasPrefix(line, "-") || strings.HasPrefix(line, "+") {
+				edits++
+			}
+		}
+		if !assert.Equal(t, len(a)+len(b)-2*lcs(a, b), edits, `edit script should be the shortest (old=%q, new=%q)`, old, new) {
+			return
+		}
+		if !assert.Equal(t, new, patch(a, got), `applying the diff should produce the new input (old=%q, new=%q)`, old, new) {
+			return
+		}
+	}
+}
+
+func TestUnifiedLarge(t *testing.T) {
+	const lines = 5000
+
+	var old, new strings.Builder
+	for i := 0; i < lines; i++ {
+		fmt.Fprintf(&old, "old line %d\n", i)
+		fmt.Fprintf(&new, "new line %d\n", i)
+	}
+
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	got := diff.Unified("old", "new", []byte(old.String()), []byte(new.String()))
+	runtime.ReadMemStats(&after)
+
+	if !assert.Equal(t, 2*lines+3, bytes.Count(got, []byte{'\n'}), `every line should be replaced`) {
+		return
+	}
+	if !assert.Less(t, after.TotalAlloc-before.TotalAlloc, uint64(16<<20), `memory usage should be proportional to the input`) {
+		return
+	}
+}
+
+// lcs returns the length of the longest common subsequence of a and b
+func lcs(a, b []string) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for i := range a {
+		for j := range b {
+			switch {
+			case a[i] == b[j]:
+				cur[j+1] = prev[j] + 1
+			case prev[j+1] > cur[j]:
+				cur[j+1] = prev[j+1]
+			default:
+				cur[j+1] = cur[j]
+			}
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
+
+// patch applies the unified diff to the lines in `old`
+func patch(old []string, unified string) string {
+	var dst strings.Builder
+	var next int // index of the next line in old to be copied
+	for _, line := range strings.SplitAfter(unified, "\n")[2:] {
+		switch {
+		case strings.HasPrefix(line, "@@"):
+			var start int
+			fmt.Sscanf(line, "@@ -%d", &start)
+			if !strings.Contains(strings.Fields(line)[1], ",0") {
+				start-- // empty ranges refer to the line before them
+			}
+			for ; next < start; next++ {
+				dst.WriteString(old[next])
+			}
+		case strings.HasPrefix(line, "+"):
+			dst.WriteString(line[1:])
+		case strings.HasPrefix(line, " "), strings.HasPrefix(line, "-"):
+			next++
+			if strings.HasPrefix(line, " ") {
+				dst.WriteString(line[1:])
+			}
+		}
+	}
+	for ; next < len(old); next++ {
+		dst.WriteString(old[next])
+	}
+	return dst.String()
+}
@@ -0,0 +1,123 @@
+package codegen
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+)
+
+// DecodeJSON decodes the JSON document in `src` into `v` in the same way
+// as DecodeJSONC, except that comments and trailing commas are rejected.
+// Use it instead of json.Unmarshal to decode specs with WithStrict, or to
+// get errors with the path and position relative to the whole document
+func DecodeJSON(src []byte, v interface{}, options ...Option) error {
+	return decodeJSONDocument(src, src, v, strictConfigFromOptions(options))
+}
+
+// forEachJSONField calls fn for each key in the JSON object in `data`,
+// along with a function to decode the corresponding value. Errors are
+// reported as DecodeError, using the key as the path of the value
+func forEachJSONField(data []byte, fn func(string, func(interface{}) error) error) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	tok, err := dec.Token()
+	if err != nil {
+		return decodeError(data, "", dec.InputOffset(), fmt.Errorf(`failed to read next token: %w`, err))
+	}
+
+	if tok != json.Delim('{') {
+		return decodeError(data, "", 0, fmt.Errorf(`expected '{', got %#v`, tok))
+	}
+
+	for {
+		offset := dec.InputOffset()
+		tok, err := dec.Token()
+		if err != nil {
+			return decodeError(data, "", offset, fmt.Errorf(`failed to read next token: %w`, err))
+		}
+
+		switch tok := tok.(type) {
+		case json.Delim:
+			if tok == '}' {
+				return nil
+			}
+			return decodeError(data, "", offset, fmt.Errorf(`unexpected delimiter %#v`, tok))
+		case string:
+			start := skipJSONSeparators(data, dec.InputOffset())
+			if err := fn(tok, dec.Decode); err != nil {
+				return decodeError(data, tok, start, err)
+			}
+		default:
+			return decodeError(data, "", offset, fmt.Errorf(`invalid token: %#v`, tok))
+		}
+	}
+}
+
+// forEachJSONElement calls fn for each element in the JSON array in
+// `data`. Errors are reported as DecodeError, using the index of the
+// element as the path of the value
+func forEachJSONElement(data []byte, fn func(int, json.RawMessage) error) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	tok, err := dec.Token()
+	if err != nil {
+		return decodeError(data, "", dec.InputOffset(), fmt.Errorf(`failed to read next token: %w`, err))
+	}
+
+	if tok != json.Delim('[') {
+		return decodeError(data, "", 0, fmt.Errorf(`expected '[', got %#v`, tok))
+	}
+
+	for i := 0; dec.More(); i++ {
+		start := skipJSONSeparators(data, dec.InputOffset())
+		var raw json.RawMessage
+		if err := dec.Decode(&raw); err != nil {
+			return decodeError(data, fmt.Sprintf(`[%d]`, i), start, err)
+		}
+
+		if err := fn(i, raw); err != nil {
+			return decodeError(data, fmt.Sprintf(`[%d]`, i), start, err)
+		}
+	}
+	return nil
+}
+
+// skipJSONSeparators returns the offset of the first byte at or after
+// `offset` that is not whitespace, a colon, or a comma: that is, the
+// beginning of the next value
+func skipJSONSeparators(data []byte, offset int64) int64 {
+	for ; offset < int64(len(data)); offset++ {
+		switch data[offset] {
+		case ' ', '\t', '\r', '\n', ':', ',':
+		default:
+			return offset
+		}
+	}
+	return offset
+}
+
+// marshalJSONMembers encodes the members as a JSON object, preserving
+// their order. HTML characters are not escaped, so that type names such
+// as `<-chan int` remain readable when encoded using a json.Encoder with
+// SetEscapeHTML(false)
+func marshalJSONMembers(list []member) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+
+	buf.WriteByte('{')
+	for i, m := range list {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		if err := enc.Encode(m.key); err != nil {
+			return nil, fmt.Errorf(`failed to encode key %q: %w`, m.key, err)
+		}
+		buf.Truncate(buf.Len() - 1) // Encode appends a newline
+		buf.WriteByte(':')
+		if err := enc.Encode(m.value); err != nil {
+			return nil, fmt.Errorf(`failed to encode field %q: %w`, m.key, err)
+		}
+		buf.Truncate(buf.Len() - 1)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
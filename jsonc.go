@@ -0,0 +1,236 @@
+package codegen
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// StandardizeJSONC converts JSON with comments (JSONC) to standard JSON.
+// Line comments (// ...), block comments (/* ... */), and trailing commas
+// before a closing '}' or ']' are accepted.
+//
+// Removed bytes are replaced with spaces rather than being deleted, and
+// newlines are kept intact, so that offsets, lines, and columns in the
+// result refer to the same locations in the original source.
+//
+// Only comments and trailing commas are supported: other JSON5 extensions
+// such as unquoted keys or single quoted strings are not
+func StandardizeJSONC(src []byte) ([]byte, error) {
+	dst := make([]byte, len(src))
+	copy(dst, src)
+
+	pendingComma := -1 // offset of a comma that may be a trailing comma
+	for i := 0; i < len(dst); i++ {
+		switch c := dst[i]; c {
+		case '"':
+			pendingComma = -1
+			// skip to the end of the string. unterminated strings are
+			// left for the JSON decoder to report
+			for i++; i < len(dst); i++ {
+				if dst[i] == '\\' {
+					i++
+					continue
+				}
+				if dst[i] == '"' {
+					break
+				}
+			}
+		case '/':
+			if i+1 >= len(dst) {
+				continue
+			}
+			switch dst[i+1] {
+			case '/':
+				for ; i < len(dst) && dst[i] != '\n'; i++ {
+					dst[i] = ' '
+				}
+			case '*':
+				start := i
+				end := bytes.Index(dst[i+2:], []byte("*/"))
+				if end < 0 {
+					line, col := lineColumn(src, int64(start))
+					return nil, fmt.Errorf(`line %d, column %d: unterminated block comment`, line, col)
+				}
+				end += i + 4
+				for ; i < end; i++ {
+					if dst[i] != '\n' {
+						dst[i] = ' '
+					}
+				}
+				i--
+			}
+		case ',':
+			pendingComma = i
+		case '}', ']':
+			if pendingComma >= 0 {
+				dst[pendingComma] = ' '
+			}
+			pendingComma = -1
+		case ' ', '\t', '\r', '\n':
+		default:
+			pendingComma = -1
+		}
+	}
+	return dst, nil
+}
+
+// DecodeJSONC decodes JSON with comments and trailing commas into `v`,
+// using the same decoding logic as json.Unmarshal (including the
+// UnmarshalJSON methods of Object and FieldList). Syntax and type errors
+// are reported with the line and column in the original source, and
+// errors from decoding specs are returned as DecodeError, with the path
+// and position relative to the whole document.
+//
+// Unknown keys are stored as extras, unless WithStrict is specified.
+// Strict mode applies to all spec values in the document, wherever they
+// appear in `v`. Use DecodeJSON to reject comments and trailing commas
+func DecodeJSONC(src []byte, v interface{}, options ...Option) error {
+	data, err := StandardizeJSONC(src)
+	if err != nil {
+		return fmt.Errorf(`failed to parse JSONC: %w`, err)
+	}
+	return decodeJSONDocument(src, data, v, strictConfigFromOptions(options))
+}
+
+// decodeJSONDocument decodes the standard JSON in `data` into `v`, and
+// reports errors relative to `src`, which `data` was created from
+// without moving any bytes around
+func decodeJSONDocument(src, data []byte, v interface{}, strict *strictConfig) error {
+	if err := decodeJSON(data, v, strict); err != nil {
+		// positions in errors from UnmarshalJSON methods are relative to
+		// the value that was being decoded, which json.Unmarshal passes
+		// as a subslice of the document
+		var de DecodeError
+		if errors.As(err, &de) {
+			start, ok := subsliceOffset(data, de.src)
+			if !ok {
+				return de
+			}
+			de.path = joinDecodePath(jsonPathAt(data, start), de.path)
+			de.offset += start
+			de.src = src
+			de.line, de.column = lineColumn(src, de.offset)
+			de.document = true
+			return de
+		}
+
+		var offset int64 = -1
+		switch err := err.(type) {
+		case *json.SyntaxError:
+			// Offset is the number of bytes read, including the
+			// byte that caused the error
+			offset = err.Offset - 1
+		case *json.UnmarshalTypeError:
+			offset = err.Offset
+		}
+
+		if offset >= 0 {
+			line, col := lineColumn(src, offset)
+			return fmt.Errorf(`line %d, column %d: %w`, line, col, err)
+		}
+		return err
+	}
+	return nil
+}
+
+// lineColumn returns the 1-based line and column of the byte at `offset`
+func lineColumn(src []byte, offset int64) (int, int) {
+	if offset > int64(len(src)) {
+		offset = int64(len(src))
+	}
+
+	before := src[:offset]
+	line := bytes.Count(before, []byte{'\n'}) + 1
+	col := len(before) - bytes.LastIndexByte(before, '\n')
+	return line, col
+}
+
+// subsliceOffset returns the offset of `sub` within `data`, if `sub`
+// is a subslice of `data` (i.e. they share the same backing array).
+//
+// The capacity of `sub` can not be used to compute the offset, as
+// json.Unmarshal limits it to the length of the value, so the element
+// addresses are compared instead
+func subsliceOffset(data, sub []byte) (int64, bool) {
+	if len(sub) == 0 {
+		return 0, false
+	}
+
+	for i := 0; i+len(sub) <= len(data); i++ {
+		if &data[i] == &sub[0] {
+			return int64(i), true
+		}
+	}
+	return 0, false
+}
+
+// jsonPathAt returns the path to the value that starts at `offset` in
+// the JSON document `data`, such as `objects[2]`. Returns an empty string
+// if the value is the document itself, or could not be found
+func jsonPathAt(data []byte, offset int64) string {
+	type frame struct {
+		object    bool
+		key       string // key of the current value, for objects
+		index     int    // index of the current value, for arrays
+		expectKey bool
+	}
+
+	var stack []*frame
+	dec := json.NewDecoder(bytes.NewReader(data))
+	for {
+		var top *frame
+		if len(stack) > 0 {
+			top = stack[len(stack)-1]
+		}
+
+		start := skipJSONSeparators(data, dec.InputOffset())
+		if start > offset {
+			return ""
+		}
+
+		tok, err := dec.Token()
+		if err != nil {
+			return ""
+		}
+
+		if d, ok := tok.(json.Delim); ok && (d == '}' || d == ']') {
+			stack = stack[:len(stack)-1]
+			continue
+		}
+
+		if top != nil && top.object && top.expectKey {
+			top.key, _ = tok.(string)
+			top.expectKey = false
+			continue
+		}
+
+		if top != nil {
+			if top.object {
+				top.expectKey = true
+			} else {
+				top.index++
+			}
+		}
+
+		if start == offset {
+			var path string
+			for _, f := range stack {
+				if f.object {
+					path = joinDecodePath(path, f.key)
+				} else {
+					path += fmt.Sprintf(`[%d]`, f.index)
+				}
+			}
+			return path
+		}
+
+		switch tok {
+		case json.Delim('{'):
+			stack = append(stack, &frame{object: true, expectKey: true})
+		case json.Delim('['):
+			stack = append(stack, &frame{index: -1})
+		}
+	}
+}
@@ -0,0 +1,124 @@
+package codegen
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+)
+
+// Markers that delimit hand-written regions in generated code:
+//
+//	// codegen:keep begin <id>
+//	... hand-written code ...
+//	// codegen:keep end <id>
+var (
+	rxKeepBegin = regexp.MustCompile(`^\s*// codegen:keep begin (\S+)\s*$`)
+	rxKeepEnd   = regexp.MustCompile(`^\s*// codegen:keep end(?:\s+(\S+))?\s*$`)
+)
+
+// keepRegion describes the contents of a region between a pair of
+// markers. `start` and `end` are the offsets of the contents, which
+// exclude the marker lines themselves
+type keepRegion struct {
+	id    string
+	start int
+	end   int
+}
+
+// findKeepRegions returns the regions delimited by keep markers in src
+func findKeepRegions(src []byte) ([]keepRegion, error) {
+	var regions []keepRegion
+	seen := make(map[string]struct{})
+
+	var current *keepRegion
+	var currentLine int
+	lineno := 0
+	for offset := 0; offset < len(src); {
+		lineno++
+		next := bytes.IndexByte(src[offset:], '\n')
+		if next < 0 {
+			next = len(src)
+		} else {
+			next += offset + 1
+		}
+		line := bytes.TrimRight(src[offset:next], "\r\n")
+
+		if m := rxKeepBegin.FindSubmatch(line); m != nil {
+			id := string(m[1])
+			if current != nil {
+				return nil, fmt.Errorf(`line %d: region %q begins before region %q (line %d) ends`, lineno, id, current.id, currentLine)
+			}
+			if _, ok := seen[id]; ok {
+				return nil, fmt.Errorf(`line %d: duplicate region %q`, lineno, id)
+			}
+			seen[id] = struct{}{}
+			current = &keepRegion{id: id, start: next}
+			currentLine = lineno
+		} else if m := rxKeepEnd.FindSubmatch(line); m != nil {
+			if current == nil {
+				return nil, fmt.Errorf(`line %d: end of region without a matching begin`, lineno)
+			}
+			if id := string(m[1]); id != "" && id != current.id {
+				return nil, fmt.Errorf(`line %d: end of region %q does not match region %q (line %d)`, lineno, id, current.id, currentLine)
+			}
+			current.end = offset
+			regions = append(regions, *current)
+			current = nil
+		}
+		offset = next
+	}
+
+	if current != nil {
+		return nil, fmt.Errorf(`line %d: region %q is never closed`, currentLine, current.id)
+	}
+	return regions, nil
+}
+
+// spliceKeepRegions replaces the contents of the regions in the newly
+// generated code with the contents of the regions of the same name in
+// the existing code. It is an error for a region in the existing code
+// to be missing from the generated code.
+func spliceKeepRegions(generated, existing []byte) ([]byte, error) {
+	oldRegions, err := findKeepRegions(existing)
+	if err != nil {
+		return nil, fmt.Errorf(`failed to parse regions in existing code: %w`, err)
+	}
+	if len(oldRegions) == 0 {
+		return generated, nil
+	}
+
+	newRegions, err := findKeepRegions(generated)
+	if err != nil {
+		return nil, fmt.Errorf(`failed to parse regions in generated code: %w`, err)
+	}
+
+	preserved := make(map[string][]byte, len(oldRegions))
+	for _, r := range oldRegions {
+		preserved[r.id] = existing[r.start:r.end]
+	}
+
+	var dst bytes.Buffer
+	prev := 0
+	for _, r := range newRegions {
+		contents, ok := preserved[r.id]
+		if !ok {
+			continue
+		}
+		delete(preserved, r.id)
+
+		dst.Write(generated[prev:r.start])
+		dst.Write(contents)
+		prev = r.end
+	}
+	dst.Write(generated[prev:])
+
+	if len(preserved) > 0 {
+		// report in the order they appear in the existing code
+		for _, r := range oldRegions {
+			if _, ok := preserved[r.id]; ok {
+				return nil, fmt.Errorf(`region %q in the existing code does not exist in the generated code`, r.id)
+			}
+		}
+	}
+	return dst.Bytes(), nil
+}
@@ -0,0 +1,172 @@
+package codegen
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+// Manifest records the files that were produced in a single run of a
+// generator, along with the hashes of their contents.
+//
+// By saving the manifest at the end of each run, files that were
+// generated in a previous run but are no longer produced (e.g. because
+// the object they were generated from has been removed from the spec)
+// can be removed using Cleanup.
+//
+// A Manifest is safe for concurrent use.
+type Manifest struct {
+	mu    sync.Mutex
+	files map[string]string // filename -> hash of the contents
+}
+
+type manifestJSON struct {
+	Files map[string]string `json:"files"`
+}
+
+func NewManifest() *Manifest {
+	return &Manifest{
+		files: make(map[string]string),
+	}
+}
+
+// LoadManifest reads a manifest previously written using Save. If the
+// file does not exist, an empty manifest is returned.
+//
+// The manifest is read from the file system specified using WithFS,
+// which defaults to OSFS
+func LoadManifest(filename string, options ...Option) (*Manifest, error) {
+	data, err := fsFromOptions(options).ReadFile(filename)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return NewManifest(), nil
+		}
+		return nil, fmt.Errorf(`failed to read manifest %s: %w`, filename, err)
+	}
+
+	var v manifestJSON
+	if err := json.Unmarshal(data, &v); err != nil {
+		return nil, fmt.Errorf(`failed to decode manifest %s: %w`, filename, err)
+	}
+
+	m := NewManifest()
+	for fn, hash := range v.Files {
+		m.files[fn] = hash
+	}
+	return m, nil
+}
+
+// Save writes the manifest to `filename`. Files are listed in sorted
+// order, so that the manifest itself can be checked into version control.
+//
+// The manifest is written to the file system specified using WithFS,
+// which defaults to OSFS
+func (m *Manifest) Save(filename string, options ...Option) error {
+	fs := fsFromOptions(options)
+
+	m.mu.Lock()
+	data, err := json.MarshalIndent(manifestJSON{Files: m.files}, "", "  ")
+	m.mu.Unlock()
+	if err != nil {
+		return fmt.Errorf(`failed to encode manifest: %w`, err)
+	}
+	data = append(data, '\n')
+
+	if existing, err := fs.ReadFile(filename); err == nil && bytes.Equal(existing, data) {
+		return nil
+	}
+	if err := fs.WriteFile(filename, data); err != nil {
+		return fmt.Errorf(`failed to write manifest %s: %w`, filename, err)
+	}
+	return nil
+}
+
+// Add records that `filename` was produced with the given contents
+func (m *Manifest) Add(filename string, data []byte) {
+	hash := contentHash(data)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.files[filepath.Clean(filename)] = hash
+}
+
+// contentHash returns the hash of data as recorded in the manifest
+func contentHash(data []byte) string {
+	sum := sha256.Sum256(data)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+// Has returns true if `filename` is recorded in the manifest
+func (m *Manifest) Has(filename string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	_, ok := m.files[filepath.Clean(filename)]
+	return ok
+}
+
+// Hash returns the hash of the contents of `filename`, in the form
+// "sha256:<hex digest>"
+func (m *Manifest) Hash(filename string) (string, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	v, ok := m.files[filepath.Clean(filename)]
+	return v, ok
+}
+
+// Files returns the sorted list of files recorded in the manifest
+func (m *Manifest) Files() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	list := make([]string, 0, len(m.files))
+	for fn := range m.files {
+		list = append(list, fn)
+	}
+	sort.Strings(list)
+	return list
+}
+
+// Cleanup removes the files that are recorded in the manifest `prev`
+// from a previous run, but not in the manifest `current`.
+//
+// As a safety measure, files are only removed if they are still marked
+// as generated (see IsGenerated), and their contents still match the
+// hash recorded in `prev`: files that have been edited or replaced by
+// hand are left alone. Files that no longer exist are ignored.
+//
+// Files are removed from the file system specified using WithFS, which
+// defaults to OSFS. Returns the list of files that were removed
+func Cleanup(prev, current *Manifest, options ...Option) ([]string, error) {
+	fs := fsFromOptions(options)
+
+	var removed []string
+	for _, fn := range prev.Files() {
+		if current.Has(fn) {
+			continue
+		}
+
+		data, err := fs.ReadFile(fn)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return removed, fmt.Errorf(`failed to read %s: %w`, fn, err)
+		}
+
+		if hash, _ := prev.Hash(fn); hash != contentHash(data) || !IsGenerated(data) {
+			continue
+		}
+
+		if err := fs.Remove(fn); err != nil {
+			return removed, fmt.Errorf(`failed to remove %s: %w`, fn, err)
+		}
+		removed = append(removed, fn)
+	}
+	return removed, nil
+}
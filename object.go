@@ -45,6 +45,11 @@ type base struct {
 	unexportedName string
 	comment        string
 	extras         map[string]interface{}
+
+	// names derived from `name` by Name, when they are not specified.
+	// These are kept separately so that they are not encoded
+	derivedExportedName   string
+	derivedUnexportedName string
 }
 
 func (b *base) Initialize() {
@@ -53,6 +58,8 @@ func (b *base) Initialize() {
 	b.unexportedName = ""
 	b.comment = ""
 	b.extras = make(map[string]interface{})
+	b.derivedExportedName = ""
+	b.derivedUnexportedName = ""
 }
 
 func (b *base) Extra(s string) (interface{}, bool) {
@@ -60,7 +67,11 @@ func (b *base) Extra(s string) (interface{}, bool) {
 	return v, ok
 }
 
-func (b *base) handleJSONField(dec *json.Decoder, field string) (bool, error) {
+// handleField decodes the value for `field` using `decode`, if the field
+// is one of the known attributes. Returns false if the field is unknown.
+// Errors from `decode` are returned as is, so that the caller can add
+// the location of the field
+func (b *base) handleField(field string, decode func(interface{}) error) (bool, error) {
 	var fref interface{}
 	switch field {
 	case "name":
@@ -74,10 +85,45 @@ func (b *base) handleJSONField(dec *json.Decoder, field string) (bool, error) {
 	default:
 		return false, nil
 	}
-	if err := dec.Decode(fref); err != nil {
-		return true, fmt.Errorf(`failed to decode field %q: %w`, field, err)
+	return true, decode(fref)
+}
+
+// member is a key/value pair in an encoded object or field
+type member struct {
+	key   string
+	value interface{}
+}
+
+// members returns the attributes of the object or field that have
+// been set, in the order that they are encoded
+func (b *base) members() []member {
+	var list []member
+	if b.name != "" {
+		list = append(list, member{key: "name", value: b.name})
+	}
+	if b.exportedName != "" {
+		list = append(list, member{key: "exported_name", value: b.exportedName})
 	}
-	return true, nil
+	if b.unexportedName != "" {
+		list = append(list, member{key: "unexported_name", value: b.unexportedName})
+	}
+	if b.comment != "" {
+		list = append(list, member{key: "comment", value: b.comment})
+	}
+	return list
+}
+
+// extraMembers returns the extras sorted by key, so that they are
+// always encoded in the same order
+func (b *base) extraMembers() []member {
+	list := make([]member, 0, len(b.extras))
+	for key, value := range b.extras {
+		list = append(list, member{key: key, value: value})
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].key < list[j].key
+	})
+	return list
 }
 
 func (b *base) Comment() string {
@@ -89,26 +135,32 @@ func (b *base) Name(exported bool) string {
 		if v := b.exportedName; v != "" {
 			return v
 		}
+		if v := b.derivedExportedName; v != "" {
+			return v
+		}
 
 		if strings.ToUpper(b.name) == b.name {
-			b.exportedName = b.name
+			b.derivedExportedName = b.name
 		} else {
-			b.exportedName = xstrings.Camel(b.name)
+			b.derivedExportedName = xstrings.Camel(b.name)
 		}
-		return b.exportedName
+		return b.derivedExportedName
 	}
 
 	if v := b.unexportedName; v != "" {
 		return v
 	}
+	if v := b.derivedUnexportedName; v != "" {
+		return v
+	}
 
 	v := xstrings.Camel(b.name)
 	if strings.ToUpper(v) == v {
-		b.unexportedName = strings.ToLower(v)
+		b.derivedUnexportedName = strings.ToLower(v)
 	} else {
-		b.unexportedName = xstrings.LcFirst(v)
+		b.derivedUnexportedName = xstrings.LcFirst(v)
 	}
-	return b.unexportedName
+	return b.derivedUnexportedName
 }
 
 type Object struct {
@@ -143,74 +195,68 @@ func (o *Object) AddField(f Field) {
 	o.fields = append(o.fields, f)
 }
 
-func (o *Object) UnmarshalJSON(data []byte) error {
-	o.base.Initialize()
-	dec := json.NewDecoder(bytes.NewReader(data))
-	tok, err := dec.Token()
-	if err != nil {
-		return fmt.Errorf(`failed to read next token: %w`, err)
+func (o *Object) members() []member {
+	list := o.base.members()
+	if o.objectOf != "" {
+		list = append(list, member{key: "object_of", value: o.objectOf})
 	}
-
-	switch tok := tok.(type) {
-	case json.Delim:
-		if tok != '{' {
-			return fmt.Errorf(`expected '{', got %#v`, tok)
-		}
-	default:
-		return fmt.Errorf(`expected '{', got %#v`, tok)
+	if o.arrayOf != "" {
+		list = append(list, member{key: "array_of", value: o.arrayOf})
+	}
+	if o.fields != nil {
+		list = append(list, member{key: "fields", value: o.fields})
 	}
+	return append(list, o.extraMembers()...)
+}
+
+// MarshalJSON encodes the object, including its fields and extras.
+// Known attributes are encoded first, followed by the extras sorted
+// by key, so that the output is deterministic
+func (o Object) MarshalJSON() ([]byte, error) {
+	return marshalJSONMembers(o.members())
+}
+
+func (o *Object) UnmarshalJSON(data []byte) error {
+	return o.unmarshalJSON(data, strictJSONConfig(data))
+}
 
-OUTER:
-	for {
-		tok, err := dec.Token()
-		if err != nil {
-			return fmt.Errorf(`failed to read next token: %w`, err)
+func (o *Object) unmarshalJSON(data []byte, strict *strictConfig) error {
+	o.base.Initialize()
+	return forEachJSONField(data, func(key string, decode func(interface{}) error) error {
+		if handled, err := o.handleField(key, decode); handled {
+			return err
 		}
 
-		switch tok := tok.(type) {
-		case json.Delim:
-			if tok == '}' {
-				break OUTER
-			}
-			return fmt.Errorf(`unexpected delimiter %#v`, tok)
-		case string:
-			handled, err := o.handleJSONField(dec, tok)
-			if err != nil {
+		var fref interface{}
+		switch key {
+		case "object_of":
+			fref = &o.objectOf
+		case "array_of":
+			fref = &o.arrayOf
+		case "fields":
+			var raw json.RawMessage
+			if err := decode(&raw); err != nil {
 				return err
 			}
-
-			if handled {
-				continue OUTER
+			var fl FieldList
+			if err := fl.unmarshalJSON(raw, strict); err != nil {
+				return err
 			}
-
-			var fref interface{}
-			switch tok {
-			case "object_of":
-				fref = &o.objectOf
-			case "array_of":
-				fref = &o.arrayOf
-			case "fields":
-				var fl FieldList
-				if err := dec.Decode(&fl); err != nil {
-					return fmt.Errorf(`failed to decode field list: %w`, err)
-				}
-				o.fields = fl
-				continue OUTER
-			default:
-				var v interface{}
-				if err := dec.Decode(&v); err != nil {
-					return fmt.Errorf(`failed to decode extra field %q: %w`, tok, err)
-				}
-				o.extras[tok] = v
-				continue OUTER
+			o.fields = fl
+			return nil
+		default:
+			if err := strict.checkObjectKey(key); err != nil {
+				return err
 			}
-
-			if err := dec.Decode(fref); err != nil {
-				return fmt.Errorf(`failed to decode field %q: %w`, tok, err)
+			var v interface{}
+			if err := decode(&v); err != nil {
+				return err
 			}
+			o.extras[key] = v
+			return nil
 		}
-	}
-	return nil
+		return decode(fref)
+	})
 }
 
 // Returns the value of field `name` as a boolean
@@ -236,44 +282,70 @@ func (o *Object) MustString(name string) string {
 	return v
 }
 
+// ObjectList is a list of objects, such as all of the objects described
+// in a spec. Errors from decoding an ObjectList include the index of the
+// object that failed to decode
+type ObjectList []*Object
+
+func (l *ObjectList) UnmarshalJSON(data []byte) error {
+	return l.unmarshalJSON(data, strictJSONConfig(data))
+}
+
+func (l *ObjectList) unmarshalJSON(data []byte, strict *strictConfig) error {
+	*l = make([]*Object, 0)
+	if bytes.Equal(data, []byte("null")) {
+		return nil
+	}
+
+	return forEachJSONElement(data, func(_ int, raw json.RawMessage) error {
+		var o Object
+		if err := o.unmarshalJSON(raw, strict); err != nil {
+			return err
+		}
+		*l = append(*l, &o)
+		return nil
+	})
+}
+
 type FieldList []Field
 
 func (l *FieldList) UnmarshalJSON(data []byte) error {
-	dec := json.NewDecoder(bytes.NewReader(data))
+	return l.unmarshalJSON(data, strictJSONConfig(data))
+}
 
-	var list []json.RawMessage
-	if err := dec.Decode(&list); err != nil {
-		return fmt.Errorf(`failed to decode field list to list of messages`)
+func (l *FieldList) unmarshalJSON(data []byte, strict *strictConfig) error {
+	*l = make([]Field, 0)
+	if bytes.Equal(data, []byte("null")) {
+		return nil
 	}
 
-	*l = make([]Field, 0, len(list))
-	// if the message contains `constant`, it's a constant
-	for i, raw := range list {
+	return forEachJSONElement(data, func(_ int, raw json.RawMessage) error {
+		// if the message contains `constant`, it's a constant
 		var probe struct {
 			Constant json.RawMessage `json:"constant"`
 		}
 		if err := json.Unmarshal(raw, &probe); err != nil {
-			return fmt.Errorf(`failed to decode probe for field %d: %w`, i+1, err)
+			return err
 		}
 
 		var f Field
 		if len(probe.Constant) > 0 {
 			var c ConstantField
-			if err := json.Unmarshal(raw, &c); err != nil {
-				return fmt.Errorf(`failed to decode constant field %d: %w`, i+1, err)
+			if err := c.unmarshalJSON(raw, strict); err != nil {
+				return err
 			}
 			f = &c
 		} else {
 			var s stdField
-			if err := json.Unmarshal(raw, &s); err != nil {
-				return fmt.Errorf(`failed to decode field %d: %w`, i+1, err)
+			if err := s.unmarshalJSON(raw, strict); err != nil {
+				return err
 			}
 			f = &s
 		}
 
 		*l = append(*l, f)
-	}
-	return nil
+		return nil
+	})
 }
 
 type Field interface {
@@ -308,6 +380,7 @@ type stdField struct {
 	base
 	skipMethod   bool
 	typ          string
+	defaultTyp   string // type used by Organize when typ is not specified
 	jsonName     string
 	getterMethod string
 	required     bool
@@ -315,7 +388,7 @@ type stdField struct {
 
 func (f *stdField) Organize() {
 	if f.typ == "" {
-		f.typ = "string"
+		f.defaultTyp = "string"
 	}
 }
 
@@ -324,7 +397,10 @@ func (f *stdField) SkipMethod() bool {
 }
 
 func (f *stdField) Type() string {
-	return f.typ
+	if v := f.typ; v != "" {
+		return v
+	}
+	return f.defaultTyp
 }
 
 func (f *stdField) JSON() string {
@@ -342,14 +418,9 @@ func (f *stdField) GetterMethod(exported bool) string {
 	return xstrings.Camel(f.name)
 }
 
-func (f *stdField) handleJSONField(dec *json.Decoder, field string) (bool, error) {
-	ok, err := f.base.handleJSONField(dec, field)
-	if err != nil {
-		return false, err
-	}
-
-	if ok {
-		return ok, nil
+func (f *stdField) handleField(field string, decode func(interface{}) error) (bool, error) {
+	if ok, err := f.base.handleField(field, decode); ok {
+		return ok, err
 	}
 
 	var fref interface{}
@@ -367,64 +438,55 @@ func (f *stdField) handleJSONField(dec *json.Decoder, field string) (bool, error
 	default:
 		return false, nil
 	}
-	if err := dec.Decode(fref); err != nil {
-		return true, fmt.Errorf(`failed to decode field %q: %w`, field, err)
+	return true, decode(fref)
+}
+
+func (f *stdField) members() []member {
+	list := f.base.members()
+	if f.typ != "" {
+		list = append(list, member{key: "type", value: f.typ})
+	}
+	if f.jsonName != "" {
+		list = append(list, member{key: "json", value: f.jsonName})
+	}
+	if f.getterMethod != "" {
+		list = append(list, member{key: "getter", value: f.getterMethod})
+	}
+	if f.skipMethod {
+		list = append(list, member{key: "skip_method", value: f.skipMethod})
 	}
-	return true, nil
+	if f.required {
+		list = append(list, member{key: "required", value: f.required})
+	}
+	return list
+}
 
+func (f stdField) MarshalJSON() ([]byte, error) {
+	return marshalJSONMembers(append(f.members(), f.extraMembers()...))
 }
 
 func (f *stdField) UnmarshalJSON(data []byte) error {
-	f.base.Initialize()
-
-	dec := json.NewDecoder(bytes.NewReader(data))
-	tok, err := dec.Token()
-	if err != nil {
-		return fmt.Errorf(`failed to read next token: %w`, err)
-	}
+	return f.unmarshalJSON(data, strictJSONConfig(data))
+}
 
-	switch tok := tok.(type) {
-	case json.Delim:
-		if tok != '{' {
-			return fmt.Errorf(`expected '{', got %#v`, tok)
+func (f *stdField) unmarshalJSON(data []byte, strict *strictConfig) error {
+	f.base.Initialize()
+	return forEachJSONField(data, func(key string, decode func(interface{}) error) error {
+		if handled, err := f.handleField(key, decode); handled {
+			return err
 		}
-	default:
-		return fmt.Errorf(`expected '{', got %#v`, tok)
-	}
 
-OUTER:
-	for {
-		tok, err := dec.Token()
-		if err != nil {
-			return fmt.Errorf(`failed to read next token: %w`, err)
+		if err := strict.checkFieldKey(key, fieldKeys); err != nil {
+			return err
 		}
 
-		switch tok := tok.(type) {
-		case json.Delim:
-			if tok == '}' {
-				break OUTER
-			}
-			return fmt.Errorf(`unexpected delimiter %#v`, tok)
-		case string:
-			handled, err := f.handleJSONField(dec, tok)
-			if err != nil {
-				return err
-			}
-
-			if handled {
-				continue OUTER
-			}
-			var v interface{}
-			if err := dec.Decode(&v); err != nil {
-				return fmt.Errorf(`failed to decode extra field %q: %w`, tok, err)
-			}
-			f.extras[tok] = v
-			continue OUTER
-		default:
-			return fmt.Errorf(`invalid token: %#v`, tok)
+		var v interface{}
+		if err := decode(&v); err != nil {
+			return err
 		}
-	}
-	return nil
+		f.extras[key] = v
+		return nil
+	})
 }
 
 func (f *stdField) MustBool(s string) bool {
@@ -460,70 +522,56 @@ type ConstantField struct {
 	value interface{}
 }
 
-func (f *ConstantField) UnmarshalJSON(data []byte) error {
+func (f *ConstantField) reset() {
 	f.name = ""
+	f.derivedExportedName = ""
+	f.derivedUnexportedName = ""
 	f.typ = ""
+	f.defaultTyp = ""
 	f.jsonName = ""
 	f.value = nil
-
-	dec := json.NewDecoder(bytes.NewReader(data))
-	tok, err := dec.Token()
-	if err != nil {
-		return fmt.Errorf(`failed to read next token: %w`, err)
+	if f.extras == nil {
+		f.extras = make(map[string]interface{})
 	}
+}
 
-	switch tok := tok.(type) {
-	case json.Delim:
-		if tok != '{' {
-			return fmt.Errorf(`expected '{', got %#v`, tok)
-		}
-	default:
-		return fmt.Errorf(`expected '{', got %#v`, tok)
-	}
+func (f *ConstantField) members() []member {
+	// `constant` is always present, as it is what distinguishes
+	// constants from other fields
+	list := append(f.stdField.members(), member{key: "constant", value: f.value})
+	return append(list, f.extraMembers()...)
+}
 
-OUTER:
-	for {
-		tok, err := dec.Token()
-		if err != nil {
-			return fmt.Errorf(`failed to read next token: %w`, err)
-		}
+func (f ConstantField) MarshalJSON() ([]byte, error) {
+	return marshalJSONMembers(f.members())
+}
 
-		switch tok := tok.(type) {
-		case json.Delim:
-			if tok == '}' {
-				break OUTER
-			}
-			return fmt.Errorf(`unexpected delimiter %#v`, tok)
-		case string:
-			handled, err := f.handleJSONField(dec, tok)
-			if err != nil {
-				return err
-			}
+func (f *ConstantField) UnmarshalJSON(data []byte) error {
+	return f.unmarshalJSON(data, strictJSONConfig(data))
+}
 
-			if handled {
-				continue OUTER
-			}
+func (f *ConstantField) unmarshalJSON(data []byte, strict *strictConfig) error {
+	f.reset()
+	return forEachJSONField(data, func(key string, decode func(interface{}) error) error {
+		if handled, err := f.handleField(key, decode); handled {
+			return err
+		}
 
-			var fref interface{}
-			switch tok {
-			case "constant":
-				fref = &f.value
-			default:
-				var v interface{}
-				if err := dec.Decode(&v); err != nil {
-					return fmt.Errorf(`failed to decode extra field %q: %w`, tok, err)
-				}
-				f.extras[tok] = v
-				continue OUTER
+		switch key {
+		case "constant":
+			return decode(&f.value)
+		default:
+			if err := strict.checkFieldKey(key, constantFieldKeys); err != nil {
+				return err
 			}
-
-			if err := dec.Decode(fref); err != nil {
-				return fmt.Errorf(`failed to decode field %q: %w`, tok, err)
+			var v interface{}
+			if err := decode(&v); err != nil {
+				return err
 			}
+			f.extras[key] = v
+			return nil
 		}
-	}
-
-	return nil
+	})
 }
 
 func (f *ConstantField) Bool(s string) bool {
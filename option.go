@@ -1,11 +1,35 @@
 package codegen
 
-import "github.com/lestrrat-go/option"
+import (
+	"io"
+
+	"github.com/lestrrat-go/option"
+)
 
 type Option = option.Interface
 
 type identFormatCode struct{}
 type identLineNumber struct{}
+type identGeneratedHeader struct{}
+type identGeneratedSource struct{}
+type identLegacyBuildTags struct{}
+type identDryRun struct{}
+type identFormatter struct{}
+type identFilename struct{}
+type identLocalPrefix struct{}
+type identFormatOnly struct{}
+type identTabWidth struct{}
+type identPostProcessor struct{}
+type identDumpOnError struct{}
+type identTypeCheck struct{}
+type identKeepRegions struct{}
+type identExistingCode struct{}
+type identManifest struct{}
+type identConcurrency struct{}
+type identFS struct{}
+type identStrict struct{}
+type identAllowedObjectKeys struct{}
+type identAllowedFieldKeys struct{}
 
 func WithFormatCode(b bool) Option {
 	return option.New(identFormatCode{}, b)
@@ -14,3 +38,176 @@ func WithFormatCode(b bool) Option {
 func WithLineNumber(b bool) Option {
 	return option.New(identLineNumber{}, b)
 }
+
+// WithGeneratedHeader specifies that the standard
+// "// Code generated by <tool>; DO NOT EDIT." line should be
+// inserted at the top of the generated code, so that linters
+// and code review tools can recognize it as generated code.
+//
+// Nothing is inserted if the tool name is empty, or if the
+// code already contains such a line.
+func WithGeneratedHeader(tool string) Option {
+	return option.New(identGeneratedHeader{}, tool)
+}
+
+// WithGeneratedSource specifies the path of the file that the code
+// was generated from, which is mentioned in the header inserted by
+// WithGeneratedHeader. It has no effect on its own.
+func WithGeneratedSource(path string) Option {
+	return option.New(identGeneratedSource{}, path)
+}
+
+// WithLegacyBuildTags specifies that build constraints declared using
+// Output.BuildConstraint should also be rendered as `// +build` lines,
+// for toolchains older than Go 1.17. It has no effect when writing
+// from an io.Reader using Write or WriteFile directly.
+func WithLegacyBuildTags(b bool) Option {
+	return option.New(identLegacyBuildTags{}, b)
+}
+
+// WithDryRun specifies that WriteFile should not modify any files.
+// Instead, the differences between the existing file and the code that
+// would have been written are written to `w` in the unified diff format.
+// Nothing is written if there are no differences.
+func WithDryRun(w io.Writer) Option {
+	return option.New(identDryRun{}, w)
+}
+
+// WithFormatter specifies the tool used to format the code when
+// WithFormatCode(true) is specified. The default is FormatGoimports.
+func WithFormatter(f Formatter) Option {
+	return option.New(identFormatter{}, f)
+}
+
+// WithFilename specifies the name of the file that the code is going
+// to be written to. It is used to resolve imports from other files in
+// the same package when formatting, and in error messages.
+//
+// WriteFile and CheckFile automatically use the name of the target file.
+func WithFilename(s string) Option {
+	return option.New(identFilename{}, s)
+}
+
+// WithLocalPrefix specifies a comma-separated list of import path
+// prefixes, which are placed in a separate group after third-party
+// imports when formatting the code with FormatGoimports.
+func WithLocalPrefix(s string) Option {
+	return option.New(identLocalPrefix{}, s)
+}
+
+// WithFormatOnly specifies that FormatGoimports should only format the
+// code and sort the imports, without adding or removing any imports.
+func WithFormatOnly(b bool) Option {
+	return option.New(identFormatOnly{}, b)
+}
+
+// WithTabWidth specifies the tab width used when aligning code.
+// The default is 8, which is what gofmt uses.
+func WithTabWidth(n int) Option {
+	return option.New(identTabWidth{}, n)
+}
+
+// PostProcessor is a function that transforms the generated code.
+type PostProcessor func([]byte) ([]byte, error)
+
+// WithPostProcessor adds a function that transforms the generated code,
+// such as inserting a license header or checking the code for problems.
+// This option may be specified multiple times, in which case the
+// post processors are applied in the order that they were specified.
+//
+// Post processors are applied after the code has been formatted, and
+// before line numbers are added. An error returned from a post processor
+// aborts the generation, and is reported along with the position of the
+// post processor that failed.
+func WithPostProcessor(pp PostProcessor) Option {
+	return option.New(identPostProcessor{}, pp)
+}
+
+// WithDumpOnError specifies that when WriteFile fails to format the code,
+// the unformatted code should be written next to the target file, with
+// the ".broken" suffix appended to its name (e.g. "foo_gen.go.broken").
+// The returned CodeFormatError reports the name of that file. The file
+// is removed once the code is successfully written.
+func WithDumpOnError(b bool) Option {
+	return option.New(identDumpOnError{}, b)
+}
+
+// WithTypeCheck specifies that the generated code should be type checked
+// using go/types before it is written out. If the name of the target file
+// is known (see WithFilename), the other files in the same directory that
+// belong to the same package are checked along with it.
+//
+// Errors are reported as a TypeCheckError.
+func WithTypeCheck(b bool) Option {
+	return option.New(identTypeCheck{}, b)
+}
+
+// WithKeepRegions specifies that WriteFile and CheckFile should preserve
+// hand-written regions in the existing file across regeneration.
+// Regions are delimited by marker comments on their own lines:
+//
+//	// codegen:keep begin <id>
+//	... hand-written code ...
+//	// codegen:keep end <id>
+//
+// The generated code must contain the same markers. Anything between
+// the markers in the generated code is replaced by the contents of the
+// region with the same id in the existing file, if there is one.
+// It is an error for a region in the existing file to be missing from
+// the generated code, so that hand-written code is never silently lost.
+func WithKeepRegions(b bool) Option {
+	return option.New(identKeepRegions{}, b)
+}
+
+// withExistingCode passes the contents of the file that is about to
+// be overwritten, for use with WithKeepRegions
+func withExistingCode(b []byte) Option {
+	return option.New(identExistingCode{}, b)
+}
+
+// WithManifest specifies a Manifest that WriteFile should record
+// the written file in. See Cleanup for removing files that were
+// generated in a previous run, but not in the current one.
+func WithManifest(m *Manifest) Option {
+	return option.New(identManifest{}, m)
+}
+
+// WithConcurrency specifies the maximum number of files that a Session
+// processes at the same time. The default is the number of CPUs.
+func WithConcurrency(n int) Option {
+	return option.New(identConcurrency{}, n)
+}
+
+// WithFS specifies the file system that WriteFile, CheckFile, Session,
+// and the Manifest functions read from and write to. The default is OSFS.
+//
+// Note that WithTypeCheck always reads the other files in the package
+// from the OS file system.
+func WithFS(fs FS) Option {
+	return option.New(identFS{}, fs)
+}
+
+// WithStrict specifies that DecodeJSON, DecodeJSONC, and DecodeYAML
+// should reject keys in objects and fields that are not recognized,
+// instead of storing them as extras. Keys that are used as extras must
+// be declared using WithAllowedObjectKeys and WithAllowedFieldKeys.
+//
+// Strict mode applies to every Object and field in the document, including
+// those nested in other types. It does not apply when decoding using
+// json.Unmarshal or yaml.Unmarshal, as these can not pass options to the
+// UnmarshalJSON and UnmarshalYAML methods
+func WithStrict(b bool) Option {
+	return option.New(identStrict{}, b)
+}
+
+// WithAllowedObjectKeys specifies extra keys that are allowed in objects
+// when decoding in strict mode. It has no effect on its own.
+func WithAllowedObjectKeys(keys ...string) Option {
+	return option.New(identAllowedObjectKeys{}, keys)
+}
+
+// WithAllowedFieldKeys specifies extra keys that are allowed in fields
+// when decoding in strict mode. It has no effect on its own.
+func WithAllowedFieldKeys(keys ...string) Option {
+	return option.New(identAllowedFieldKeys{}, keys)
+}
@@ -0,0 +1,181 @@
+package codegen
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"sort"
+)
+
+// section is a named placeholder in an Output, whose contents
+// are written to a separate Output and spliced in when the
+// code is written out.
+type section struct {
+	name     string
+	offset   int64 // -1 if the section has not been reserved yet
+	reserved int   // number of times Reserve was called
+	seq      int   // order in which the section was reserved
+	out      *Output
+}
+
+// Reserve marks the current position as the location of the section
+// `name`, and returns the Output that the contents of the section
+// should be written to.
+//
+// The section may be filled at any time, before or after the rest of
+// the code has been written: this is useful for emitting code near the
+// top of the file (such as helper variables or a list of types) which
+// can only be computed after the rest of the file has been generated.
+// The contents are spliced in when Write or WriteFile is called.
+//
+// Sections reserved at the same position appear in the order that
+// they were reserved, regardless of when they were first written to.
+// Reserving the same name twice is an error, which is reported from
+// Write or WriteFile.
+//
+// Code written to the destination without going through the Output
+// is accounted for if the destination reports its length, as
+// *bytes.Buffer does. Otherwise Write and WriteFile fail, as the
+// position of the section can not be determined
+func (o *Output) Reserve(name string) *Output {
+	s := o.section(name)
+	s.reserved++
+	if s.reserved == 1 {
+		s.offset = o.position()
+		s.seq = o.reservations
+		o.reservations++
+		// the section starts out in the same state as the parent
+		s.out.indent = o.indent
+		s.out.bol = o.bol
+	}
+	return s.out
+}
+
+// Section returns the Output for the section `name`. The section
+// must be reserved using Reserve before the code is written out.
+func (o *Output) Section(name string) *Output {
+	return o.section(name).out
+}
+
+func (o *Output) section(name string) *section {
+	if s, ok := o.sections[name]; ok {
+		return s
+	}
+
+	if o.sections == nil {
+		o.sections = make(map[string]*section)
+	}
+
+	out := NewOutput(&bytes.Buffer{})
+	out.indentString = o.indentString
+	out.imports = o.imports
+
+	s := &section{
+		name:   name,
+		offset: -1,
+		out:    out,
+	}
+	o.sections[name] = s
+	o.sectionOrder = append(o.sectionOrder, s)
+	return s
+}
+
+type insertion struct {
+	offset int64
+	data   []byte
+}
+
+// splice inserts each of the insertions into buf. Insertions at the
+// same offset are inserted in the order that they appear in `list`
+func splice(buf []byte, list []insertion) []byte {
+	if len(list) == 0 {
+		return buf
+	}
+
+	sort.SliceStable(list, func(i, j int) bool {
+		return list[i].offset < list[j].offset
+	})
+
+	size := len(buf)
+	for _, ins := range list {
+		size += len(ins.data)
+	}
+
+	dst := make([]byte, 0, size)
+	var prev int64
+	for _, ins := range list {
+		dst = append(dst, buf[prev:ins.offset]...)
+		dst = append(dst, ins.data...)
+		prev = ins.offset
+	}
+	return append(dst, buf[prev:]...)
+}
+
+// readSource returns all of the code written to the Output so far.
+// Reading from the destination consumes it, so the code is kept
+// around, and only the code written since the last call is read.
+// This allows the same Output to be written out more than once
+func (o *Output) readSource() ([]byte, error) {
+	if o.src == nil {
+		return nil, fmt.Errorf(`failed to read from source: destination is not readable`)
+	}
+
+	buf, err := ioutil.ReadAll(o.src)
+	if err != nil {
+		return nil, fmt.Errorf(`failed to read from source: %w`, err)
+	}
+	o.read = append(o.read, buf...)
+	return o.read, nil
+}
+
+// render reads the code written to the Output, and returns it with
+// the contents of all sections spliced in. Additional insertions
+// may be given in `extra`, which precede sections at the same offset
+func (o *Output) render(extra ...insertion) ([]byte, error) {
+	buf, err := o.readSource()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(o.sectionOrder) > 0 && !o.exactPositions(buf) {
+		return nil, fmt.Errorf(`failed to locate sections: the destination contains code that was not written through the Output`)
+	}
+
+	list := make([]insertion, 0, len(extra)+len(o.sectionOrder))
+	for _, ins := range extra {
+		if ins.offset > int64(len(buf)) {
+			return nil, fmt.Errorf(`insertion offset %d is past the end of the source`, ins.offset)
+		}
+		list = append(list, ins)
+	}
+
+	// sections at the same offset appear in the order they were
+	// reserved, which is not necessarily the order they were created in
+	sections := make([]*section, len(o.sectionOrder))
+	copy(sections, o.sectionOrder)
+	sort.SliceStable(sections, func(i, j int) bool {
+		return sections[i].seq < sections[j].seq
+	})
+	for _, s := range sections {
+		if s.reserved == 0 {
+			return nil, fmt.Errorf(`section %q was written to but never reserved`, s.name)
+		}
+		if s.reserved > 1 {
+			return nil, fmt.Errorf(`section %q was reserved %d times`, s.name, s.reserved)
+		}
+		if s.offset > int64(len(buf)) {
+			return nil, fmt.Errorf(`section %q is reserved past the end of the source`, s.name)
+		}
+
+		if err := s.out.err; err != nil {
+			return nil, fmt.Errorf(`failed to write section %q: %w`, s.name, err)
+		}
+
+		data, err := s.out.render()
+		if err != nil {
+			return nil, fmt.Errorf(`failed to render section %q: %w`, s.name, err)
+		}
+		list = append(list, insertion{offset: s.offset, data: data})
+	}
+	return splice(buf, list), nil
+}
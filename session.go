@@ -0,0 +1,263 @@
+package codegen
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"runtime"
+	"strings"
+	"sync"
+)
+
+// Session collects the code for many files, so that they can be
+// formatted and written concurrently.
+//
+//	s := codegen.NewSession()
+//	for _, obj := range objects {
+//	  o := codegen.NewOutput(&bytes.Buffer{})
+//	  ... generate code ...
+//	  s.Add(obj.Name(false)+"_gen.go", o)
+//	}
+//	if err := s.WriteFiles(codegen.WithFormatCode(true)); err != nil {
+//	  ...
+//	}
+//
+// The same session may be processed more than once, for example using
+// WriteFiles followed by CheckFiles. The code is read from each Output
+// or reader the first time it is needed, and kept for later calls.
+// Calls to WriteFiles and CheckFiles must not overlap.
+type Session struct {
+	mu    sync.Mutex
+	files []*sessionFile
+}
+
+type sessionFile struct {
+	filename string
+	out      *Output
+	src      io.Reader
+	data     []byte // code read from src, once it has been read
+}
+
+// reader returns the code added using AddReader. The code is read
+// the first time, so that the file can be processed again later
+func (f *sessionFile) reader() (io.Reader, error) {
+	if f.src != nil {
+		buf, err := ioutil.ReadAll(f.src)
+		if err != nil {
+			return nil, fmt.Errorf(`failed to read from source: %w`, err)
+		}
+		f.data = buf
+		f.src = nil
+	}
+	return bytes.NewReader(f.data), nil
+}
+
+func NewSession() *Session {
+	return &Session{}
+}
+
+// Add registers the code written to `o`, to be written to `filename`
+func (s *Session) Add(filename string, o *Output) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.files = append(s.files, &sessionFile{filename: filename, out: o})
+}
+
+// AddReader registers the code read from `src`, to be written to `filename`
+func (s *Session) AddReader(filename string, src io.Reader) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.files = append(s.files, &sessionFile{filename: filename, src: src})
+}
+
+// Files returns the names of the files registered in the session,
+// in the order they were added
+func (s *Session) Files() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	list := make([]string, len(s.files))
+	for i, f := range s.files {
+		list[i] = f.filename
+	}
+	return list
+}
+
+// WriteFiles writes all of the files registered in the session using
+// WriteFile, with at most WithConcurrency files processed at the same
+// time. The same options are used for every file.
+//
+// Unlike calling WriteFile in a loop, a failure to write one file does
+// not stop the others from being written. If any of the files fail,
+// a SessionError listing all of the failures is returned.
+//
+// When WithDryRun is specified, the diffs are written in the order
+// that the files were added to the session.
+func (s *Session) WriteFiles(options ...Option) error {
+	var dryRun io.Writer
+	for _, option := range options {
+		switch option.Ident() {
+		case identDryRun{}:
+			dryRun = option.Value().(io.Writer)
+		}
+	}
+
+	var diffs []bytes.Buffer
+	if dryRun != nil {
+		diffs = make([]bytes.Buffer, len(s.files))
+	}
+
+	err := s.run(options, func(i int, f *sessionFile, options []Option) error {
+		if dryRun != nil {
+			// collect the diffs separately, as they are generated
+			// concurrently and must not be interleaved
+			options = append(options, WithDryRun(&diffs[i]))
+		}
+		if f.out != nil {
+			return f.out.WriteFile(f.filename, options...)
+		}
+		src, err := f.reader()
+		if err != nil {
+			return err
+		}
+		return WriteFile(f.filename, src, options...)
+	})
+
+	for i := range diffs {
+		if _, werr := diffs[i].WriteTo(dryRun); werr != nil && err == nil {
+			err = fmt.Errorf(`failed to write diff: %w`, werr)
+		}
+	}
+	return err
+}
+
+// CheckFiles checks all of the files registered in the session using
+// CheckFile, with at most WithConcurrency files processed at the same
+// time.
+//
+// If the only problem is that some of the files are out of date, a single
+// StaleError listing all of them is returned. Otherwise, if any of the
+// files fail, a SessionError listing all of the failures is returned.
+func (s *Session) CheckFiles(options ...Option) error {
+	err := s.run(options, func(_ int, f *sessionFile, options []Option) error {
+		if f.out != nil {
+			return f.out.CheckFile(f.filename, options...)
+		}
+		src, err := f.reader()
+		if err != nil {
+			return err
+		}
+		return CheckFile(f.filename, src, options...)
+	})
+
+	var serr SessionError
+	if !errors.As(err, &serr) {
+		return err
+	}
+
+	var stale []string
+	for _, ferr := range serr.errors {
+		var staleErr StaleError
+		if !errors.As(ferr.Err, &staleErr) {
+			return err
+		}
+		stale = append(stale, staleErr.Files()...)
+	}
+	return staleError(stale...)
+}
+
+// run calls fn for each of the files, using a bounded pool of workers
+func (s *Session) run(options []Option, fn func(int, *sessionFile, []Option) error) error {
+	concurrency := runtime.NumCPU()
+	for _, option := range options {
+		switch option.Ident() {
+		case identConcurrency{}:
+			concurrency = option.Value().(int)
+		}
+	}
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	s.mu.Lock()
+	files := make([]*sessionFile, len(s.files))
+	copy(files, s.files)
+	s.mu.Unlock()
+
+	seen := make(map[string]struct{}, len(files))
+	for _, f := range files {
+		if _, ok := seen[f.filename]; ok {
+			return fmt.Errorf(`file %s was added to the session more than once`, f.filename)
+		}
+		seen[f.filename] = struct{}{}
+	}
+
+	errs := make([]error, len(files))
+	indices := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < concurrency && w < len(files); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indices {
+				// each call gets its own copy of the options
+				errs[i] = fn(i, files[i], append([]Option(nil), options...))
+			}
+		}()
+	}
+
+	for i := range files {
+		indices <- i
+	}
+	close(indices)
+	wg.Wait()
+
+	var serr SessionError
+	for i, err := range errs {
+		if err != nil {
+			serr.errors = append(serr.errors, FileError{Filename: files[i].filename, Err: err})
+		}
+	}
+	if len(serr.errors) > 0 {
+		return serr
+	}
+	return nil
+}
+
+// FileError describes a failure to process a single file in a Session
+type FileError struct {
+	Filename string
+	Err      error
+}
+
+func (err FileError) Error() string {
+	return fmt.Sprintf(`%s: %s`, err.Filename, err.Err)
+}
+
+func (err FileError) Unwrap() error {
+	return err.Err
+}
+
+// SessionError is returned from Session when one or more of the
+// files could not be processed
+type SessionError struct {
+	errors []FileError
+}
+
+func (err SessionError) Error() string {
+	var buf strings.Builder
+	fmt.Fprintf(&buf, `failed to process %d file(s):`, len(err.errors))
+	for _, ferr := range err.errors {
+		buf.WriteString("\n\t")
+		buf.WriteString(ferr.Error())
+	}
+	return buf.String()
+}
+
+// Errors returns the errors for each of the files that failed,
+// in the order that the files were added to the session
+func (err SessionError) Errors() []FileError {
+	return err.errors
+}
@@ -0,0 +1,264 @@
+package codegen
+
+import (
+	"encoding/json"
+	"reflect"
+	"sort"
+	"sync"
+
+	"gopkg.in/yaml.v3"
+)
+
+// keys that are recognized when decoding objects and fields. These are
+// used to suggest corrections for unknown keys in strict mode
+var (
+	objectKeys        = []string{"name", "exported_name", "unexported_name", "comment", "object_of", "array_of", "fields"}
+	fieldKeys         = []string{"name", "exported_name", "unexported_name", "comment", "type", "json", "getter", "skip_method", "required"}
+	constantFieldKeys = append(append([]string(nil), fieldKeys...), "constant")
+)
+
+// strictConfig holds the keys that are allowed in addition to the
+// known keys when decoding in strict mode. A nil *strictConfig
+// allows any key
+type strictConfig struct {
+	objectKeys map[string]struct{}
+	fieldKeys  map[string]struct{}
+}
+
+func newStrictConfig(objectKeys, fieldKeys []string) *strictConfig {
+	cfg := &strictConfig{
+		objectKeys: make(map[string]struct{}),
+		fieldKeys:  make(map[string]struct{}),
+	}
+	for _, key := range objectKeys {
+		cfg.objectKeys[key] = struct{}{}
+	}
+	for _, key := range fieldKeys {
+		cfg.fieldKeys[key] = struct{}{}
+	}
+	return cfg
+}
+
+func (cfg *strictConfig) checkObjectKey(key string) error {
+	if cfg == nil {
+		return nil
+	}
+	return checkKey(key, objectKeys, cfg.objectKeys)
+}
+
+func (cfg *strictConfig) checkFieldKey(key string, known []string) error {
+	if cfg == nil {
+		return nil
+	}
+	return checkKey(key, known, cfg.fieldKeys)
+}
+
+func checkKey(key string, known []string, allowed map[string]struct{}) error {
+	if _, ok := allowed[key]; ok {
+		return nil
+	}
+
+	candidates := make([]string, 0, len(known)+len(allowed))
+	candidates = append(candidates, known...)
+	for k := range allowed {
+		candidates = append(candidates, k)
+	}
+	sort.Strings(candidates)
+
+	return UnknownKeyError{
+		key:        key,
+		suggestion: suggestKey(key, candidates),
+	}
+}
+
+// suggestKey returns the candidate closest to `key`, or an empty
+// string if none of the candidates are similar enough
+func suggestKey(key string, candidates []string) string {
+	const maxDistance = 2
+
+	var best string
+	bestDistance := maxDistance + 1
+	for _, candidate := range candidates {
+		if d := editDistance(key, candidate); d < bestDistance {
+			best = candidate
+			bestDistance = d
+		}
+	}
+	return best
+}
+
+// editDistance returns the optimal string alignment distance between
+// a and b: the number of insertions, deletions, substitutions, and
+// transpositions of adjacent bytes required to turn a into b
+func editDistance(a, b string) int {
+	// rows i-2, i-1, and i of the distance matrix
+	prev2 := make([]int, len(b)+1)
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+
+			d := prev[j-1] + cost
+			if v := prev[j] + 1; v < d {
+				d = v
+			}
+			if v := cur[j-1] + 1; v < d {
+				d = v
+			}
+			if i > 1 && j > 1 && a[i-1] == b[j-2] && a[i-2] == b[j-1] {
+				if v := prev2[j-2] + 1; v < d {
+					d = v
+				}
+			}
+			cur[j] = d
+		}
+		prev2, prev, cur = prev, cur, prev2
+	}
+	return prev[len(b)]
+}
+
+// strictConfigFromOptions returns the strict mode configuration
+// specified using WithStrict, WithAllowedObjectKeys, and
+// WithAllowedFieldKeys. Returns nil if strict mode is not enabled
+func strictConfigFromOptions(options []Option) *strictConfig {
+	var strict bool
+	var allowedObjectKeys, allowedFieldKeys []string
+	for _, option := range options {
+		switch option.Ident() {
+		case identStrict{}:
+			strict = option.Value().(bool)
+		case identAllowedObjectKeys{}:
+			allowedObjectKeys = append(allowedObjectKeys, option.Value().([]string)...)
+		case identAllowedFieldKeys{}:
+			allowedFieldKeys = append(allowedFieldKeys, option.Value().([]string)...)
+		}
+	}
+
+	if !strict {
+		return nil
+	}
+	return newStrictConfig(allowedObjectKeys, allowedFieldKeys)
+}
+
+// strictDocuments associates the documents that are being decoded in
+// strict mode with their configuration.
+//
+// The UnmarshalJSON and UnmarshalYAML methods can not be passed any
+// options, but json.Unmarshal and yaml.Node.Decode call them with parts
+// of the document being decoded: a subslice of the JSON source, or a
+// node in the YAML tree. This is used to look up the configuration, so
+// that spec values nested anywhere in the target (such as a field of a
+// user defined struct) are decoded in strict mode
+var strictDocuments = struct {
+	mu   sync.RWMutex
+	json []strictJSONDocument
+	yaml map[*yaml.Node]*strictConfig
+}{
+	yaml: make(map[*yaml.Node]*strictConfig),
+}
+
+type strictJSONDocument struct {
+	data   []byte
+	start  uintptr // address of the first byte of data
+	config *strictConfig
+}
+
+// decodeJSON decodes `data` into `v` using json.Unmarshal, applying the
+// strict mode configuration to spec values if it is non-nil
+func decodeJSON(data []byte, v interface{}, strict *strictConfig) error {
+	if strict == nil || len(data) == 0 {
+		return json.Unmarshal(data, v)
+	}
+
+	doc := strictJSONDocument{
+		data:   data,
+		start:  reflect.ValueOf(data).Pointer(),
+		config: strict,
+	}
+	strictDocuments.mu.Lock()
+	strictDocuments.json = append(strictDocuments.json, doc)
+	strictDocuments.mu.Unlock()
+
+	defer func() {
+		strictDocuments.mu.Lock()
+		defer strictDocuments.mu.Unlock()
+		list := strictDocuments.json
+		for i := range list {
+			if list[i].start == doc.start {
+				strictDocuments.json = append(list[:i], list[i+1:]...)
+				break
+			}
+		}
+	}()
+	return json.Unmarshal(data, v)
+}
+
+// strictJSONConfig returns the strict mode configuration for `data`,
+// which is passed to an UnmarshalJSON method, or nil if it is not part
+// of a document being decoded in strict mode
+func strictJSONConfig(data []byte) *strictConfig {
+	if len(data) == 0 {
+		return nil
+	}
+
+	ptr := reflect.ValueOf(data).Pointer()
+	strictDocuments.mu.RLock()
+	defer strictDocuments.mu.RUnlock()
+	for _, doc := range strictDocuments.json {
+		if ptr >= doc.start && ptr < doc.start+uintptr(len(doc.data)) {
+			return doc.config
+		}
+	}
+	return nil
+}
+
+// decodeYAML decodes `node` into `v`, applying the strict mode
+// configuration to spec values if it is non-nil
+func decodeYAML(node *yaml.Node, v interface{}, strict *strictConfig) error {
+	if strict == nil {
+		return node.Decode(v)
+	}
+
+	var nodes []*yaml.Node
+	var walk func(*yaml.Node)
+	walk = func(n *yaml.Node) {
+		nodes = append(nodes, n)
+		for _, child := range n.Content {
+			walk(child)
+		}
+	}
+	walk(node)
+
+	strictDocuments.mu.Lock()
+	for _, n := range nodes {
+		strictDocuments.yaml[n] = strict
+	}
+	strictDocuments.mu.Unlock()
+
+	defer func() {
+		strictDocuments.mu.Lock()
+		defer strictDocuments.mu.Unlock()
+		for _, n := range nodes {
+			delete(strictDocuments.yaml, n)
+		}
+	}()
+	return node.Decode(v)
+}
+
+// strictYAMLConfig returns the strict mode configuration for `node`,
+// which is passed to an UnmarshalYAML method, or nil if it is not part
+// of a document being decoded in strict mode
+func strictYAMLConfig(node *yaml.Node) *strictConfig {
+	strictDocuments.mu.RLock()
+	defer strictDocuments.mu.RUnlock()
+	return strictDocuments.yaml[node]
+}
@@ -0,0 +1,148 @@
+package codegen
+
+import (
+	"fmt"
+	"go/ast"
+	"go/build"
+	"go/importer"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+)
+
+// defaultTypeCheckFilename is the name used for the generated code in
+// error positions, when the name of the target file is not known
+const defaultTypeCheckFilename = "generated.go"
+
+// TypeCheckError is returned when the generated code fails to type check
+type TypeCheckError struct {
+	src       []byte
+	filename  string
+	positions []ErrorPosition
+}
+
+func (err TypeCheckError) Error() string {
+	if len(err.positions) == 0 {
+		return `failed to type check generated code`
+	}
+
+	msg := fmt.Sprintf(`failed to type check generated code: %s`, err.positions[0])
+	if n := len(err.positions) - 1; n > 0 {
+		msg += fmt.Sprintf(` (and %d more errors)`, n)
+	}
+	return msg
+}
+
+// Returns the generated source code with line numbers
+func (err TypeCheckError) Source() string {
+	return numberedSource(err.src)
+}
+
+// Positions returns the location of each of the errors reported by
+// the type checker. Errors may refer to other files in the same package.
+func (err TypeCheckError) Positions() []ErrorPosition {
+	return err.positions
+}
+
+// Snippet returns the lines surrounding each of the errors in the
+// generated code, with `contextLines` lines before and after the
+// failing line, and a caret pointing at the failing column.
+// Errors in other files of the same package are not included
+func (err TypeCheckError) Snippet(contextLines int) string {
+	var positions []ErrorPosition
+	for _, pos := range err.positions {
+		if pos.Filename == err.filename {
+			positions = append(positions, pos)
+		}
+	}
+	return snippet(err.src, positions, contextLines)
+}
+
+// typeCheck type checks the generated code, along with the other files
+// in the directory of `filename` that belong to the same package.
+// If `filename` is empty, the generated code is checked on its own
+func typeCheck(filename string, src []byte) error {
+	name := filename
+	if name == "" {
+		name = defaultTypeCheckFilename
+	}
+
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, name, src, parser.ParseComments)
+	if err != nil {
+		return TypeCheckError{src: src, filename: name, positions: errorPositions(err)}
+	}
+
+	files := []*ast.File{f}
+	if filename != "" {
+		siblings, err := parseSiblings(fset, filename, f.Name.Name)
+		if err != nil {
+			return err
+		}
+		files = append(files, siblings...)
+	}
+
+	var positions []ErrorPosition
+	conf := types.Config{
+		Importer: importer.ForCompiler(fset, "source", nil),
+		Error: func(err error) {
+			terr, ok := err.(types.Error)
+			if !ok {
+				positions = append(positions, ErrorPosition{Message: err.Error()})
+				return
+			}
+			pos := terr.Fset.Position(terr.Pos)
+			positions = append(positions, ErrorPosition{
+				Filename: pos.Filename,
+				Line:     pos.Line,
+				Column:   pos.Column,
+				Message:  terr.Msg,
+			})
+		},
+	}
+	_, _ = conf.Check(f.Name.Name, fset, files, nil)
+
+	if len(positions) > 0 {
+		return TypeCheckError{src: src, filename: name, positions: positions}
+	}
+	return nil
+}
+
+// parseSiblings parses the non-test Go files in the directory of
+// `filename` that belong to package `pkg`, and match the current
+// build context. The file `filename` itself is skipped, as it is
+// going to be replaced by the generated code
+func parseSiblings(fset *token.FileSet, filename, pkg string) ([]*ast.File, error) {
+	dir := filepath.Dir(filename)
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		// the target directory may not exist yet
+		return nil, nil
+	}
+
+	var files []*ast.File
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || name == filepath.Base(filename) || !strings.HasSuffix(name, ".go") || strings.HasSuffix(name, "_test.go") {
+			continue
+		}
+
+		if ok, err := build.Default.MatchFile(dir, name); err != nil || !ok {
+			continue
+		}
+
+		f, err := parser.ParseFile(fset, filepath.Join(dir, name), nil, parser.ParseComments)
+		if err != nil {
+			return nil, fmt.Errorf(`failed to parse %s: %w`, filepath.Join(dir, name), err)
+		}
+
+		if f.Name.Name != pkg {
+			continue
+		}
+		files = append(files, f)
+	}
+	return files, nil
+}
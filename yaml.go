@@ -0,0 +1,430 @@
+package codegen
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// resolveYAMLNode follows documents and aliases to the node that
+// holds the actual value
+func resolveYAMLNode(node *yaml.Node) *yaml.Node {
+	for {
+		switch {
+		case node.Kind == yaml.DocumentNode && len(node.Content) == 1:
+			node = node.Content[0]
+		case node.Kind == yaml.AliasNode && node.Alias != nil:
+			node = node.Alias
+		default:
+			return node
+		}
+	}
+}
+
+type yamlField struct {
+	key   string
+	value *yaml.Node
+}
+
+// yamlFields returns the key/value pairs in a mapping node, in order.
+// Merge keys (`<<: *base`) are expanded: keys in the mapping itself
+// take precedence over merged keys, and when a list of mappings is
+// merged, earlier mappings take precedence over later ones
+func yamlFields(node *yaml.Node) ([]yamlField, error) {
+	node = resolveYAMLNode(node)
+	if node.Kind != yaml.MappingNode {
+		return nil, fmt.Errorf(`line %d: expected a mapping, got %s`, node.Line, yamlKind(node))
+	}
+
+	var explicit, merged []yamlField
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		key := resolveYAMLNode(node.Content[i])
+		if key.Kind != yaml.ScalarNode {
+			return nil, fmt.Errorf(`line %d: expected a scalar key, got %s`, key.Line, yamlKind(key))
+		}
+
+		value := node.Content[i+1]
+		if key.ShortTag() != "!!merge" {
+			explicit = append(explicit, yamlField{key: key.Value, value: value})
+			continue
+		}
+
+		sources := []*yaml.Node{value}
+		if v := resolveYAMLNode(value); v.Kind == yaml.SequenceNode {
+			sources = v.Content
+		}
+		for _, source := range sources {
+			fields, err := yamlFields(source)
+			if err != nil {
+				return nil, fmt.Errorf(`failed to expand merge key: %w`, err)
+			}
+			merged = append(merged, fields...)
+		}
+	}
+
+	if len(merged) == 0 {
+		return explicit, nil
+	}
+
+	seen := make(map[string]struct{}, len(explicit)+len(merged))
+	list := make([]yamlField, 0, len(explicit)+len(merged))
+	for _, field := range append(explicit, merged...) {
+		if _, ok := seen[field.key]; ok {
+			continue
+		}
+		seen[field.key] = struct{}{}
+		list = append(list, field)
+	}
+	return list, nil
+}
+
+// forEachYAMLField calls fn for each key/value pair in a mapping node,
+// after merge keys have been expanded
+func forEachYAMLField(node *yaml.Node, fn func(string, *yaml.Node) error) error {
+	fields, err := yamlFields(node)
+	if err != nil {
+		return err
+	}
+
+	for _, field := range fields {
+		if err := fn(field.key, field.value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// hasYAMLField returns true if the mapping node contains the key `name`,
+// either directly or through a merge key
+func hasYAMLField(node *yaml.Node, name string) bool {
+	fields, err := yamlFields(node)
+	if err != nil {
+		return false
+	}
+	for _, field := range fields {
+		if field.key == name {
+			return true
+		}
+	}
+	return false
+}
+
+// yamlDecoder returns a function that decodes `node` into the given
+// value. Unlike yaml.Node.Decode, scalars are not converted to strings
+// or booleans: as with encoding/json, a string attribute only accepts
+// a string, and a boolean attribute only accepts true or false.
+// Null leaves the value untouched
+func yamlDecoder(node *yaml.Node) func(interface{}) error {
+	return func(v interface{}) error {
+		var expected string
+		switch v.(type) {
+		case *string:
+			expected = "!!str"
+		case *bool:
+			expected = "!!bool"
+		default:
+			return node.Decode(v)
+		}
+
+		n := resolveYAMLNode(node)
+		if n.Kind == yaml.ScalarNode {
+			switch n.ShortTag() {
+			case "!!null":
+				return nil
+			case expected:
+				return n.Decode(v)
+			}
+		}
+
+		got := yamlKind(n)
+		if n.Kind == yaml.ScalarNode {
+			got = strings.TrimPrefix(n.ShortTag(), "!!")
+		}
+		return fmt.Errorf(`line %d: cannot unmarshal %s into Go value of type %s`, n.Line, got, strings.TrimPrefix(fmt.Sprintf("%T", v), "*"))
+	}
+}
+
+// decodeYAMLValue decodes an arbitrary value, and converts it to the
+// same types that encoding/json would produce (float64 for numbers,
+// map[string]interface{} for mappings, etc), so that values stored
+// in extras are the same regardless of the format of the spec
+func decodeYAMLValue(node *yaml.Node) (interface{}, error) {
+	var v interface{}
+	if err := node.Decode(&v); err != nil {
+		return nil, err
+	}
+
+	buf, err := json.Marshal(jsonCompatible(v))
+	if err != nil {
+		return nil, fmt.Errorf(`line %d: failed to convert value: %w`, node.Line, err)
+	}
+
+	var converted interface{}
+	if err := json.Unmarshal(buf, &converted); err != nil {
+		return nil, fmt.Errorf(`line %d: failed to convert value: %w`, node.Line, err)
+	}
+	return converted, nil
+}
+
+// jsonCompatible converts mappings with non-string keys, which
+// encoding/json can not handle, to mappings with string keys
+func jsonCompatible(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for key, value := range v {
+			m[fmt.Sprint(key)] = jsonCompatible(value)
+		}
+		return m
+	case map[string]interface{}:
+		for key, value := range v {
+			v[key] = jsonCompatible(value)
+		}
+		return v
+	case []interface{}:
+		for i, value := range v {
+			v[i] = jsonCompatible(value)
+		}
+		return v
+	default:
+		return v
+	}
+}
+
+func yamlKind(node *yaml.Node) string {
+	switch node.Kind {
+	case yaml.DocumentNode:
+		return "document"
+	case yaml.SequenceNode:
+		return "sequence"
+	case yaml.MappingNode:
+		return "mapping"
+	case yaml.ScalarNode:
+		return "scalar"
+	case yaml.AliasNode:
+		return "alias"
+	default:
+		return "unknown node"
+	}
+}
+
+// DecodeYAML decodes the YAML document in `src` into `v`, using the
+// UnmarshalYAML methods of Object and FieldList.
+//
+// Unknown keys are stored as extras, unless WithStrict is specified.
+// Strict mode applies to all spec values in the document, wherever they
+// appear in `v`
+func DecodeYAML(src []byte, v interface{}, options ...Option) error {
+	var node yaml.Node
+	if err := yaml.Unmarshal(src, &node); err != nil {
+		return err
+	}
+	return decodeYAML(&node, v, strictConfigFromOptions(options))
+}
+
+// unknownYAMLKey reports an unknown key in strict mode
+func unknownYAMLKey(value *yaml.Node, err error) error {
+	return fmt.Errorf(`line %d: %w`, value.Line, err)
+}
+
+func (o *Object) UnmarshalYAML(node *yaml.Node) error {
+	return o.unmarshalYAML(node, strictYAMLConfig(node))
+}
+
+func (o *Object) unmarshalYAML(node *yaml.Node, strict *strictConfig) error {
+	o.base.Initialize()
+	return forEachYAMLField(node, func(key string, value *yaml.Node) error {
+		handled, err := o.handleField(key, yamlDecoder(value))
+		if err != nil {
+			return fmt.Errorf(`failed to decode field %q: %w`, key, err)
+		}
+
+		if handled {
+			return nil
+		}
+
+		var fref interface{}
+		switch key {
+		case "object_of":
+			fref = &o.objectOf
+		case "array_of":
+			fref = &o.arrayOf
+		case "fields":
+			var fl FieldList
+			if err := fl.unmarshalYAML(value, strict); err != nil {
+				return fmt.Errorf(`failed to decode field list: %w`, err)
+			}
+			o.fields = fl
+			return nil
+		default:
+			if err := strict.checkObjectKey(key); err != nil {
+				return unknownYAMLKey(value, err)
+			}
+			v, err := decodeYAMLValue(value)
+			if err != nil {
+				return fmt.Errorf(`failed to decode extra field %q: %w`, key, err)
+			}
+			o.extras[key] = v
+			return nil
+		}
+
+		if err := yamlDecoder(value)(fref); err != nil {
+			return fmt.Errorf(`failed to decode field %q: %w`, key, err)
+		}
+		return nil
+	})
+}
+
+func (l *ObjectList) UnmarshalYAML(node *yaml.Node) error {
+	return l.unmarshalYAML(node, strictYAMLConfig(node))
+}
+
+func (l *ObjectList) unmarshalYAML(node *yaml.Node, strict *strictConfig) error {
+	node = resolveYAMLNode(node)
+	if node.Kind != yaml.SequenceNode {
+		return fmt.Errorf(`line %d: expected a sequence, got %s`, node.Line, yamlKind(node))
+	}
+
+	*l = make([]*Object, 0, len(node.Content))
+	for i, item := range node.Content {
+		var o Object
+		if err := o.unmarshalYAML(item, strict); err != nil {
+			return fmt.Errorf(`failed to decode object %d: %w`, i+1, err)
+		}
+		*l = append(*l, &o)
+	}
+	return nil
+}
+
+func (l *FieldList) UnmarshalYAML(node *yaml.Node) error {
+	return l.unmarshalYAML(node, strictYAMLConfig(node))
+}
+
+func (l *FieldList) unmarshalYAML(node *yaml.Node, strict *strictConfig) error {
+	node = resolveYAMLNode(node)
+	if node.Kind != yaml.SequenceNode {
+		return fmt.Errorf(`failed to decode field list to list of messages`)
+	}
+
+	*l = make([]Field, 0, len(node.Content))
+	// if the message contains `constant`, it's a constant
+	for i, item := range node.Content {
+		var f Field
+		if hasYAMLField(item, "constant") {
+			var c ConstantField
+			if err := c.unmarshalYAML(item, strict); err != nil {
+				return fmt.Errorf(`failed to decode constant field %d: %w`, i+1, err)
+			}
+			f = &c
+		} else {
+			var s stdField
+			if err := s.unmarshalYAML(item, strict); err != nil {
+				return fmt.Errorf(`failed to decode field %d: %w`, i+1, err)
+			}
+			f = &s
+		}
+
+		*l = append(*l, f)
+	}
+	return nil
+}
+
+func (f *stdField) UnmarshalYAML(node *yaml.Node) error {
+	return f.unmarshalYAML(node, strictYAMLConfig(node))
+}
+
+func (f *stdField) unmarshalYAML(node *yaml.Node, strict *strictConfig) error {
+	f.base.Initialize()
+	return forEachYAMLField(node, func(key string, value *yaml.Node) error {
+		handled, err := f.handleField(key, yamlDecoder(value))
+		if err != nil {
+			return fmt.Errorf(`failed to decode field %q: %w`, key, err)
+		}
+
+		if handled {
+			return nil
+		}
+
+		if err := strict.checkFieldKey(key, fieldKeys); err != nil {
+			return unknownYAMLKey(value, err)
+		}
+
+		v, err := decodeYAMLValue(value)
+		if err != nil {
+			return fmt.Errorf(`failed to decode extra field %q: %w`, key, err)
+		}
+		f.extras[key] = v
+		return nil
+	})
+}
+
+func (f *ConstantField) UnmarshalYAML(node *yaml.Node) error {
+	return f.unmarshalYAML(node, strictYAMLConfig(node))
+}
+
+func (f *ConstantField) unmarshalYAML(node *yaml.Node, strict *strictConfig) error {
+	f.reset()
+	return forEachYAMLField(node, func(key string, value *yaml.Node) error {
+		handled, err := f.handleField(key, yamlDecoder(value))
+		if err != nil {
+			return fmt.Errorf(`failed to decode field %q: %w`, key, err)
+		}
+
+		if handled {
+			return nil
+		}
+
+		if key != "constant" {
+			if err := strict.checkFieldKey(key, constantFieldKeys); err != nil {
+				return unknownYAMLKey(value, err)
+			}
+		}
+
+		v, err := decodeYAMLValue(value)
+		if err != nil {
+			return fmt.Errorf(`failed to decode field %q: %w`, key, err)
+		}
+
+		switch key {
+		case "constant":
+			f.value = v
+		default:
+			f.extras[key] = v
+		}
+		return nil
+	})
+}
+
+// yamlMapping encodes the members as a YAML mapping, preserving their order
+func yamlMapping(list []member) (*yaml.Node, error) {
+	node := &yaml.Node{
+		Kind: yaml.MappingNode,
+		Tag:  "!!map",
+	}
+	for _, m := range list {
+		var value yaml.Node
+		if err := value.Encode(m.value); err != nil {
+			return nil, fmt.Errorf(`failed to encode field %q: %w`, m.key, err)
+		}
+		node.Content = append(node.Content,
+			&yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: m.key},
+			&value,
+		)
+	}
+	return node, nil
+}
+
+// MarshalYAML encodes the object in the same way as MarshalJSON
+func (o Object) MarshalYAML() (interface{}, error) {
+	return yamlMapping(o.members())
+}
+
+func (f stdField) MarshalYAML() (interface{}, error) {
+	return yamlMapping(append(f.members(), f.extraMembers()...))
+}
+
+func (f ConstantField) MarshalYAML() (interface{}, error) {
+	return yamlMapping(f.members())
+}